@@ -0,0 +1,27 @@
+package rubix
+
+import "time"
+
+type AccessRequestState string
+
+const (
+	AccessRequestStatePending  AccessRequestState = "pending"
+	AccessRequestStateApproved AccessRequestState = "approved"
+	AccessRequestStateDenied   AccessRequestState = "denied"
+)
+
+// AccessRequest is a member asking to be granted a role, decided by an approver
+type AccessRequest struct {
+	Workspace         string             `json:"workspace"`
+	ID                string             `json:"id"`
+	Requester         string             `json:"requester"`
+	Role              string             `json:"role"`
+	Justification     string             `json:"justification"`
+	RequestedDuration time.Duration      `json:"requestedDuration"` // 0 requests a permanent grant
+	State             AccessRequestState `json:"state"`
+	CreatedAt         time.Time          `json:"createdAt"`
+	DecidedBy         string             `json:"decidedBy"`
+	DecidedAt         time.Time          `json:"decidedAt"`
+	DecisionReason    string             `json:"decisionReason"`
+	GrantExpiresAt    time.Time          `json:"grantExpiresAt"` // Set on approval of a time-bound request
+}
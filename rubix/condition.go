@@ -1,7 +1,7 @@
 package rubix
 
 import (
-	"net"
+	"fmt"
 	"slices"
 	"strings"
 	"time"
@@ -17,98 +17,311 @@ type Condition struct {
 
 	AllowedIPGroups []string `json:"allowedIPGroups"`
 	BlockedIPGroups []string `json:"blockedIPGroups"`
+
+	// TimeWindows restricts access to the listed windows, evaluated in TimeZone
+	TimeWindows []TimeWindow `json:"timeWindows,omitempty"`
+	TimeZone    string       `json:"timeZone,omitempty"` // IANA name, defaults to UTC
+
+	// Principal filters on the member, by custom attribute key or the member.* keys, e.g. department = sales
+	Principal []AttributeFilter `json:"principal,omitempty"`
+
+	// Expression is combined with the fields above, all must pass
+	Expression *ConditionExpression `json:"expression,omitempty"`
 }
 
-// IPGroupResolver returns the entries (IPs/CIDRs) for a given group ID.
-// Returns nil if the group is not found.
-type IPGroupResolver func(groupID string) []string
+// TimeWindow is a daily window of access, e.g. a shift.
+// An End before Start spans midnight, belonging to the day it starts on.
+type TimeWindow struct {
+	Days  []string `json:"days"`  // mon, tue, wed, thu, fri, sat, sun - empty for every day
+	Start string   `json:"start"` // HH:MM inclusive
+	End   string   `json:"end"`   // HH:MM exclusive, equal to Start for the whole day
+}
 
-// ipMatchesEntry checks if an IP matches a single entry (IP or CIDR).
-func ipMatchesEntry(ip net.IP, entry string) bool {
-	if strings.Contains(entry, "/") {
-		_, cidr, err := net.ParseCIDR(entry)
-		if err != nil {
-			return false
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock converts HH:MM into minutes since midnight
+func parseClock(in string) (int, error) {
+	t, err := time.Parse("15:04", in)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w TimeWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if wd, ok := weekdays[strings.ToLower(d)]; ok && wd == day {
+			return true
 		}
-		return cidr.Contains(ip)
 	}
-	parsed := net.ParseIP(entry)
-	if parsed == nil {
+	return false
+}
+
+// Contains reports whether the local time falls within the window, malformed windows never match
+func (w TimeWindow) Contains(local time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
 		return false
 	}
-	return ip.Equal(parsed)
+
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+	switch {
+	case start == end:
+		return w.onDay(day)
+	case start < end:
+		return w.onDay(day) && minute >= start && minute < end
+	default:
+		return (w.onDay(day) && minute >= start) || (w.onDay((day+6)%7) && minute < end)
+	}
 }
 
-// collectGroupEntries resolves group IDs to a flat list of entries.
-func collectGroupEntries(groupIDs []string, resolver IPGroupResolver) []string {
-	if resolver == nil {
+// inTimeWindows reports whether the time falls in any window, an unknown time zone never matches
+func inTimeWindows(windows []TimeWindow, timeZone string, at time.Time) bool {
+	loc := time.UTC
+	if timeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timeZone); err != nil {
+			return false
+		}
+	}
+	local := at.In(loc)
+	for _, w := range windows {
+		if w.Contains(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether the condition imposes no restrictions
+func (c Condition) Empty() bool {
+	return !c.RequireMFA && !c.RequireVerifiedAccount && c.MaxSessionAgeSeconds <= 0 &&
+		len(c.AllowedLocations) == 0 && len(c.BlockedLocations) == 0 &&
+		len(c.AllowedIPGroups) == 0 && len(c.BlockedIPGroups) == 0 &&
+		len(c.TimeWindows) == 0 && len(c.Principal) == 0 && c.Expression == nil
+}
+
+// ConditionResolver supplies the workspace groups referenced by conditions.
+// Each method returns nil when the group is not known to the resolver.
+type ConditionResolver interface {
+	IPGroup(groupID string) []string
+	LocationGroup(groupID string) []string
+}
+
+// IPGroupResolver returns the entries (IPs/CIDRs) for a given group ID.
+// Returns nil if the group is not found.
+type IPGroupResolver func(groupID string) []string
+
+func (r IPGroupResolver) IPGroup(groupID string) []string {
+	if r == nil {
+		return nil
+	}
+	return r(groupID)
+}
+
+func (r IPGroupResolver) LocationGroup(string) []string { return nil }
+
+// IPMatcherResolver is implemented by resolvers holding precompiled IP groups
+type IPMatcherResolver interface {
+	IPGroupMatcher(groupID string) *IPMatcher
+}
+
+// CompiledIPGroups resolves IP groups from matchers compiled ahead of time, keyed by group ID
+type CompiledIPGroups map[string]*IPMatcher
+
+func (c CompiledIPGroups) IPGroupMatcher(groupID string) *IPMatcher { return c[groupID] }
+
+// IPGroup is unused for compiled groups, matching goes through IPGroupMatcher
+func (c CompiledIPGroups) IPGroup(string) []string { return nil }
+
+func (c CompiledIPGroups) LocationGroup(string) []string { return nil }
+
+// LocationGroupResolver returns the locations for a given group ID, matched case-insensitively.
+// Returns nil if the group is not found, falling back to BuiltinLocationGroups.
+type LocationGroupResolver func(groupID string) []string
+
+func (r LocationGroupResolver) IPGroup(string) []string { return nil }
+
+func (r LocationGroupResolver) LocationGroup(groupID string) []string {
+	if r == nil {
 		return nil
 	}
-	var all []string
-	for _, gid := range groupIDs {
-		if entries := resolver(gid); entries != nil {
-			all = append(all, entries...)
+	return r(groupID)
+}
+
+// resolveIPGroup returns the entries from the first resolver that knows the group
+func resolveIPGroup(groupID string, resolvers []ConditionResolver) []string {
+	for _, r := range resolvers {
+		if r == nil {
+			continue
+		}
+		if entries := r.IPGroup(groupID); entries != nil {
+			return entries
+		}
+	}
+	return nil
+}
+
+// resolveIPMatcher returns the compiled group from the first resolver holding precompiled groups,
+// otherwise compiling the group entries with its references expanded
+func resolveIPMatcher(groupID string, resolvers []ConditionResolver) *IPMatcher {
+	for _, r := range resolvers {
+		if mr, ok := r.(IPMatcherResolver); ok {
+			if m := mr.IPGroupMatcher(groupID); m != nil {
+				return m
+			}
 		}
 	}
-	return all
+	if resolveIPGroup(groupID, resolvers) == nil {
+		return nil
+	}
+	return NewIPMatcher(ExpandIPGroupEntries(groupID, func(id string) []string { return resolveIPGroup(id, resolvers) }))
+}
+
+// ConditionClause identifies the part of a condition that failed
+type ConditionClause string
+
+const (
+	ConditionClauseMFA             ConditionClause = "mfa"
+	ConditionClauseVerifiedAccount ConditionClause = "verifiedAccount"
+	ConditionClauseSessionAge      ConditionClause = "sessionAge"
+	ConditionClauseTimeWindow      ConditionClause = "timeWindow"
+	ConditionClauseLocation        ConditionClause = "location"
+	ConditionClauseBlockedLocation ConditionClause = "blockedLocation"
+	ConditionClauseIPGroup         ConditionClause = "ipGroup"
+	ConditionClauseBlockedIPGroup  ConditionClause = "blockedIPGroup"
+	ConditionClausePrincipal       ConditionClause = "principal"
+	ConditionClauseNot             ConditionClause = "not"
+	ConditionClauseExpression      ConditionClause = "expression"
+)
+
+// ConditionFailure describes a single failed clause, Message is suitable for display to the user
+type ConditionFailure struct {
+	Clause  ConditionClause `json:"clause"`
+	Message string          `json:"message"`
+	Path    string          `json:"path,omitempty"`   // position within the expression, empty for top level fields
+	Values  []string        `json:"values,omitempty"` // the groups or locations involved
+}
+
+type ConditionResult struct {
+	Allowed  bool               `json:"allowed"`
+	Failures []ConditionFailure `json:"failures,omitempty"`
+}
+
+// Messages returns the failure messages in evaluation order
+func (r ConditionResult) Messages() []string {
+	messages := make([]string, 0, len(r.Failures))
+	for _, f := range r.Failures {
+		messages = append(messages, f.Message)
+	}
+	return messages
 }
 
+// CheckCondition evaluates the condition, resolving IP groups through the given resolvers
 func CheckCondition(condition Condition, lookup Lookup, ipGroups ...IPGroupResolver) bool {
+	resolvers := make([]ConditionResolver, 0, len(ipGroups))
+	for _, r := range ipGroups {
+		if r != nil {
+			resolvers = append(resolvers, r)
+		}
+	}
+	return CheckConditionWith(condition, lookup, resolvers...)
+}
+
+// CheckConditionWith evaluates the condition, resolving IP and location groups through the given resolvers
+func CheckConditionWith(condition Condition, lookup Lookup, resolvers ...ConditionResolver) bool {
+	return CheckConditionDetailed(condition, lookup, resolvers...).Allowed
+}
+
+// CheckConditionDetailed evaluates every clause of the condition, reporting each one that fails.
+// Locations match hierarchically, "US" allows "US-CA", and may name built-in or workspace location groups.
+func CheckConditionDetailed(condition Condition, lookup Lookup, resolvers ...ConditionResolver) ConditionResult {
+	var failures []ConditionFailure
+	fail := func(clause ConditionClause, message string, values ...string) {
+		failures = append(failures, ConditionFailure{Clause: clause, Message: message, Values: values})
+	}
+
 	if condition.RequireMFA && !lookup.MFA {
-		return false
+		fail(ConditionClauseMFA, "multi-factor authentication is required")
 	}
 
 	if condition.RequireVerifiedAccount && !lookup.VerifiedAccount {
-		return false
+		fail(ConditionClauseVerifiedAccount, "a verified account is required")
 	}
 
-	if condition.MaxSessionAgeSeconds > 0 && time.Now().Unix()-lookup.SessionIssued.Unix() > int64(condition.MaxSessionAgeSeconds) {
-		return false
+	now := lookup.Now()
+
+	if condition.MaxSessionAgeSeconds > 0 && now.Unix()-lookup.SessionIssued.Unix() > int64(condition.MaxSessionAgeSeconds) {
+		fail(ConditionClauseSessionAge, fmt.Sprintf("session is older than %d seconds", condition.MaxSessionAgeSeconds))
+	}
+
+	if len(condition.TimeWindows) > 0 && !inTimeWindows(condition.TimeWindows, condition.TimeZone, now) {
+		fail(ConditionClauseTimeWindow, "access is not permitted at this time")
 	}
 
 	if len(condition.AllowedLocations) > 0 {
-		if !slices.Contains(condition.AllowedLocations, lookup.GeoLocation) {
-			return false
+		if !locationMatches(condition.AllowedLocations, lookup.GeoLocation, resolvers) {
+			fail(ConditionClauseLocation, fmt.Sprintf("location %q is not allowed", lookup.GeoLocation), condition.AllowedLocations...)
 		}
 	}
 
 	if len(condition.BlockedLocations) > 0 {
-		if slices.Contains(condition.BlockedLocations, lookup.GeoLocation) {
-			return false
+		if rule, blocked := matchLocation(condition.BlockedLocations, lookup.GeoLocation, resolvers); blocked {
+			fail(ConditionClauseBlockedLocation, fmt.Sprintf("location %q is blocked by %s", lookup.GeoLocation, rule), rule)
 		}
 	}
 
 	// IP Group checks
-	var resolver IPGroupResolver
-	if len(ipGroups) > 0 {
-		resolver = ipGroups[0]
+	var allowed []*IPMatcher
+	for _, gid := range condition.AllowedIPGroups {
+		if m := resolveIPMatcher(gid, resolvers); m.Len() > 0 {
+			allowed = append(allowed, m)
+		}
 	}
-
-	allowedEntries := collectGroupEntries(condition.AllowedIPGroups, resolver)
-	if len(allowedEntries) > 0 {
+	if len(allowed) > 0 {
 		if lookup.IpAddress == nil {
-			return false
+			fail(ConditionClauseIPGroup, "an IP address is required", condition.AllowedIPGroups...)
+		} else if !slices.ContainsFunc(allowed, func(m *IPMatcher) bool { return m.Contains(lookup.IpAddress) }) {
+			fail(ConditionClauseIPGroup, fmt.Sprintf("IP address %s is not in an allowed group", lookup.IpAddress), condition.AllowedIPGroups...)
 		}
-		match := false
-		for _, entry := range allowedEntries {
-			if ipMatchesEntry(lookup.IpAddress, entry) {
-				match = true
-				break
+	}
+
+	if lookup.IpAddress != nil {
+		for _, gid := range condition.BlockedIPGroups {
+			if resolveIPMatcher(gid, resolvers).Contains(lookup.IpAddress) {
+				fail(ConditionClauseBlockedIPGroup, fmt.Sprintf("IP address %s is in blocked group %s", lookup.IpAddress, gid), gid)
 			}
 		}
-		if !match {
-			return false
-		}
 	}
 
-	blockedEntries := collectGroupEntries(condition.BlockedIPGroups, resolver)
-	if len(blockedEntries) > 0 && lookup.IpAddress != nil {
-		for _, entry := range blockedEntries {
-			if ipMatchesEntry(lookup.IpAddress, entry) {
-				return false
+	if len(condition.Principal) > 0 {
+		if lookup.Principal == nil {
+			fail(ConditionClausePrincipal, "member details are not available")
+		} else {
+			for _, filter := range condition.Principal {
+				if !lookup.Principal.Match(filter) {
+					fail(ConditionClausePrincipal, fmt.Sprintf("member %s does not match %s %s", filter.Key, filter.Operator, strings.Join(filter.Values, ", ")), filter.Values...)
+				}
 			}
 		}
 	}
 
-	return true
+	if condition.Expression != nil {
+		if _, exprFailures := condition.Expression.evaluate(lookup, "expression", resolvers...); len(exprFailures) > 0 {
+			failures = append(failures, exprFailures...)
+		}
+	}
+
+	return ConditionResult{Allowed: len(failures) == 0, Failures: failures}
 }
@@ -0,0 +1,180 @@
+package rubix
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type ConditionOperator string
+
+const (
+	ConditionOperatorAnd ConditionOperator = "and"
+	ConditionOperatorOr  ConditionOperator = "or"
+	ConditionOperatorNot ConditionOperator = "not"
+)
+
+// maxExpressionDepth bounds nesting so stored expressions stay cheap to evaluate
+const maxExpressionDepth = 16
+
+// ConditionExpression combines conditions with boolean operators, e.g. "MFA or office IP".
+// A node is either an Operator applied to Operands, or a leaf holding a Condition.
+type ConditionExpression struct {
+	Operator  ConditionOperator     `json:"op,omitempty"`
+	Operands  []ConditionExpression `json:"operands,omitempty"`
+	Condition *Condition            `json:"condition,omitempty"`
+}
+
+func AllOf(operands ...ConditionExpression) ConditionExpression {
+	return ConditionExpression{Operator: ConditionOperatorAnd, Operands: operands}
+}
+
+func AnyOf(operands ...ConditionExpression) ConditionExpression {
+	return ConditionExpression{Operator: ConditionOperatorOr, Operands: operands}
+}
+
+func Not(operand ConditionExpression) ConditionExpression {
+	return ConditionExpression{Operator: ConditionOperatorNot, Operands: []ConditionExpression{operand}}
+}
+
+func Leaf(condition Condition) ConditionExpression {
+	return ConditionExpression{Condition: &condition}
+}
+
+// Evaluate reports whether the lookup satisfies the expression, an invalid expression never passes
+func (e ConditionExpression) Evaluate(lookup Lookup, resolvers ...ConditionResolver) bool {
+	ok, _ := e.evaluate(lookup, "", resolvers...)
+	return ok
+}
+
+// evaluate returns the result along with the failures explaining it, prefixed with the node path
+func (e ConditionExpression) evaluate(lookup Lookup, path string, resolvers ...ConditionResolver) (bool, []ConditionFailure) {
+	invalid := []ConditionFailure{{Clause: ConditionClauseExpression, Message: "access condition is invalid", Path: path}}
+
+	switch e.Operator {
+	case "":
+		if e.Condition == nil {
+			return false, invalid
+		}
+		result := CheckConditionDetailed(*e.Condition, lookup, resolvers...)
+		for i := range result.Failures {
+			result.Failures[i].Path = joinPath(path, result.Failures[i].Path)
+		}
+		return result.Allowed, result.Failures
+	case ConditionOperatorAnd, ConditionOperatorOr:
+		if len(e.Operands) == 0 {
+			return false, invalid
+		}
+		var failures []ConditionFailure
+		for i, operand := range e.Operands {
+			ok, operandFailures := operand.evaluate(lookup, joinPath(path, fmt.Sprintf("operands[%d]", i)), resolvers...)
+			if ok && e.Operator == ConditionOperatorOr {
+				return true, nil
+			}
+			failures = append(failures, operandFailures...)
+		}
+		return len(failures) == 0, failures
+	case ConditionOperatorNot:
+		if len(e.Operands) != 1 {
+			return false, invalid
+		}
+		if ok, _ := e.Operands[0].evaluate(lookup, joinPath(path, "operands[0]"), resolvers...); ok {
+			return false, []ConditionFailure{{Clause: ConditionClauseNot, Message: "access is excluded by condition", Path: path}}
+		}
+		return true, nil
+	}
+	return false, invalid
+}
+
+func joinPath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	}
+	return parent + "." + child
+}
+
+// Validate checks the expression is well-formed, returning the path of the first problem found
+func (e ConditionExpression) Validate() error {
+	return e.validate(1)
+}
+
+func (e ConditionExpression) validate(depth int) error {
+	if depth > maxExpressionDepth {
+		return fmt.Errorf("expression nested deeper than %d levels", maxExpressionDepth)
+	}
+
+	switch e.Operator {
+	case "":
+		if e.Condition == nil {
+			return errors.New("leaf expression requires a condition")
+		}
+		if len(e.Operands) > 0 {
+			return errors.New("leaf expression cannot have operands")
+		}
+		return e.Condition.validate(depth)
+	case ConditionOperatorAnd, ConditionOperatorOr:
+		if len(e.Operands) == 0 {
+			return fmt.Errorf("%s requires at least one operand", e.Operator)
+		}
+	case ConditionOperatorNot:
+		if len(e.Operands) != 1 {
+			return errors.New("not requires exactly one operand")
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", e.Operator)
+	}
+
+	if e.Condition != nil {
+		return fmt.Errorf("%s expression cannot have a condition", e.Operator)
+	}
+	for i, operand := range e.Operands {
+		if err := operand.validate(depth + 1); err != nil {
+			return fmt.Errorf("operands[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the condition and any expression it contains are well-formed
+func (c Condition) Validate() error {
+	return c.validate(0)
+}
+
+func (c Condition) validate(depth int) error {
+	if c.MaxSessionAgeSeconds < 0 {
+		return errors.New("maxSessionAgeSeconds cannot be negative")
+	}
+	if c.TimeZone != "" {
+		if _, err := time.LoadLocation(c.TimeZone); err != nil {
+			return fmt.Errorf("unknown time zone %q", c.TimeZone)
+		}
+	}
+	for i, w := range c.TimeWindows {
+		if _, err := parseClock(w.Start); err != nil {
+			return fmt.Errorf("timeWindows[%d]: invalid start %q", i, w.Start)
+		}
+		if _, err := parseClock(w.End); err != nil {
+			return fmt.Errorf("timeWindows[%d]: invalid end %q", i, w.End)
+		}
+		for _, d := range w.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("timeWindows[%d]: unknown day %q", i, d)
+			}
+		}
+	}
+	for i, f := range c.Principal {
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("principal[%d]: %w", i, err)
+		}
+	}
+	if c.Expression != nil {
+		if err := c.Expression.validate(depth + 1); err != nil {
+			return fmt.Errorf("expression: %w", err)
+		}
+	}
+	return nil
+}
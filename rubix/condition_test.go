@@ -198,3 +198,257 @@ func TestCheckCondition(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckConditionTimeWindows(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("tzdata unavailable")
+	}
+
+	weekdayShift := Condition{
+		TimeWindows: []TimeWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}},
+		TimeZone:    "Europe/London",
+	}
+	nightShift := Condition{
+		TimeWindows: []TimeWindow{{Days: []string{"fri"}, Start: "22:00", End: "06:00"}},
+	}
+
+	testCases := []struct {
+		name      string
+		condition Condition
+		at        time.Time
+		expected  bool
+	}{
+		{"Within weekday shift", weekdayShift, time.Date(2026, 6, 3, 10, 30, 0, 0, london), true},
+		{"Start is inclusive", weekdayShift, time.Date(2026, 6, 3, 9, 0, 0, 0, london), true},
+		{"End is exclusive", weekdayShift, time.Date(2026, 6, 3, 17, 0, 0, 0, london), false},
+		{"Weekend excluded", weekdayShift, time.Date(2026, 6, 6, 10, 30, 0, 0, london), false},
+		{"Evaluated in condition time zone", weekdayShift, time.Date(2026, 6, 3, 8, 30, 0, 0, time.UTC), true},
+		{"Overnight - before midnight", nightShift, time.Date(2026, 6, 5, 23, 0, 0, 0, time.UTC), true},
+		{"Overnight - after midnight", nightShift, time.Date(2026, 6, 6, 5, 59, 0, 0, time.UTC), true},
+		{"Overnight - next night", nightShift, time.Date(2026, 6, 6, 23, 0, 0, 0, time.UTC), false},
+		{"Whole day window", Condition{TimeWindows: []TimeWindow{{Days: []string{"SAT"}, Start: "00:00", End: "00:00"}}}, time.Date(2026, 6, 6, 13, 0, 0, 0, time.UTC), true},
+		{"Unknown time zone fails", Condition{TimeWindows: weekdayShift.TimeWindows, TimeZone: "Mars/Olympus"}, time.Date(2026, 6, 3, 10, 30, 0, 0, time.UTC), false},
+		{"Malformed window fails", Condition{TimeWindows: []TimeWindow{{Start: "9am", End: "5pm"}}}, time.Date(2026, 6, 3, 10, 30, 0, 0, time.UTC), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, CheckCondition(tc.condition, Lookup{RequestTime: tc.at}))
+		})
+	}
+}
+
+func TestConditionExpression(t *testing.T) {
+	resolver := mockResolver(map[string][]string{"office": {"10.0.0.0/8"}})
+	mfaOrOffice := Condition{Expression: ptr(AnyOf(
+		Leaf(Condition{RequireMFA: true}),
+		Leaf(Condition{AllowedIPGroups: []string{"office"}}),
+	))}
+
+	testCases := []struct {
+		name      string
+		condition Condition
+		lookup    Lookup
+		expected  bool
+	}{
+		{"Or - first operand", mfaOrOffice, Lookup{MFA: true, IpAddress: net.ParseIP("8.8.8.8")}, true},
+		{"Or - second operand", mfaOrOffice, Lookup{IpAddress: net.ParseIP("10.1.1.1")}, true},
+		{"Or - neither operand", mfaOrOffice, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, false},
+		{"Combined with fields", Condition{RequireVerifiedAccount: true, Expression: mfaOrOffice.Expression}, Lookup{MFA: true}, false},
+		{"And", Condition{Expression: ptr(AllOf(Leaf(Condition{RequireMFA: true}), Leaf(Condition{RequireVerifiedAccount: true})))}, Lookup{MFA: true}, false},
+		{"Not", Condition{Expression: ptr(Not(Leaf(Condition{BlockedLocations: []string{"FR"}})))}, Lookup{GeoLocation: "FR"}, true},
+		{"Nested", Condition{Expression: ptr(AllOf(mfaOrOffice.Expression.Operands[0], Not(Leaf(Condition{AllowedLocations: []string{"PL"}}))))}, Lookup{MFA: true, GeoLocation: "UK"}, true},
+		{"Empty and fails", Condition{Expression: &ConditionExpression{Operator: ConditionOperatorAnd}}, Lookup{}, false},
+		{"Leaf without condition fails", Condition{Expression: &ConditionExpression{}}, Lookup{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, CheckCondition(tc.condition, tc.lookup, resolver))
+		})
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	deep := Leaf(Condition{RequireMFA: true})
+	for i := 0; i < maxExpressionDepth; i++ {
+		deep = Not(deep)
+	}
+
+	testCases := []struct {
+		name      string
+		condition Condition
+		valid     bool
+	}{
+		{"Empty", Condition{}, true},
+		{"Valid expression", Condition{Expression: ptr(AnyOf(Leaf(Condition{RequireMFA: true}), Not(Leaf(Condition{BlockedLocations: []string{"FR"}}))))}, true},
+		{"Negative session age", Condition{MaxSessionAgeSeconds: -1}, false},
+		{"Unknown time zone", Condition{TimeZone: "Mars/Olympus"}, false},
+		{"Malformed window", Condition{TimeWindows: []TimeWindow{{Start: "9am", End: "17:00"}}}, false},
+		{"Unknown day", Condition{TimeWindows: []TimeWindow{{Days: []string{"someday"}, Start: "09:00", End: "17:00"}}}, false},
+		{"Or without operands", Condition{Expression: &ConditionExpression{Operator: ConditionOperatorOr}}, false},
+		{"Not with two operands", Condition{Expression: &ConditionExpression{Operator: ConditionOperatorNot, Operands: []ConditionExpression{Leaf(Condition{}), Leaf(Condition{})}}}, false},
+		{"Unknown operator", Condition{Expression: &ConditionExpression{Operator: "xor", Operands: []ConditionExpression{Leaf(Condition{})}}}, false},
+		{"Leaf without condition", Condition{Expression: &ConditionExpression{}}, false},
+		{"Invalid nested condition", Condition{Expression: ptr(AllOf(Leaf(Condition{TimeZone: "Mars/Olympus"})))}, false},
+		{"Too deep", Condition{Expression: &deep}, false},
+		{"Valid principal", Condition{Principal: []AttributeFilter{{Key: PrincipalKeyPartnerID, Operator: AttributeExists}}}, true},
+		{"Principal without value", Condition{Principal: []AttributeFilter{{Key: "department", Operator: AttributeEquals}}}, false},
+		{"Principal unknown operator", Condition{Principal: []AttributeFilter{{Key: "department", Operator: "like", Values: []string{"s%"}}}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.condition.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestCheckConditionDetailed(t *testing.T) {
+	resolver := mockResolver(map[string][]string{"office": {"10.0.0.0/8"}, "blocked": {"9.9.9.9"}})
+
+	result := CheckConditionDetailed(Condition{
+		RequireMFA:           true,
+		MaxSessionAgeSeconds: 60,
+		AllowedLocations:     []string{"GB"},
+		BlockedIPGroups:      []string{"office", "blocked"},
+	}, Lookup{GeoLocation: "FR", IpAddress: net.ParseIP("9.9.9.9"), SessionIssued: time.Now().Add(-time.Hour)}, resolver)
+
+	assert.False(t, result.Allowed)
+	assert.Equal(t, []ConditionFailure{
+		{Clause: ConditionClauseMFA, Message: "multi-factor authentication is required"},
+		{Clause: ConditionClauseSessionAge, Message: "session is older than 60 seconds"},
+		{Clause: ConditionClauseLocation, Message: `location "FR" is not allowed`, Values: []string{"GB"}},
+		{Clause: ConditionClauseBlockedIPGroup, Message: "IP address 9.9.9.9 is in blocked group blocked", Values: []string{"blocked"}},
+	}, result.Failures)
+
+	result = CheckConditionDetailed(Condition{AllowedIPGroups: []string{"office"}}, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, resolver)
+	assert.Equal(t, []string{"IP address 8.8.8.8 is not in an allowed group"}, result.Messages())
+
+	t.Run("Expression", func(t *testing.T) {
+		condition := Condition{Expression: ptr(AnyOf(
+			Leaf(Condition{RequireMFA: true}),
+			Leaf(Condition{AllowedIPGroups: []string{"office"}}),
+		))}
+		result := CheckConditionDetailed(condition, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, resolver)
+		assert.False(t, result.Allowed)
+		if assert.Len(t, result.Failures, 2) {
+			assert.Equal(t, "expression.operands[0]", result.Failures[0].Path)
+			assert.Equal(t, ConditionClauseMFA, result.Failures[0].Clause)
+			assert.Equal(t, "expression.operands[1]", result.Failures[1].Path)
+			assert.Equal(t, ConditionClauseIPGroup, result.Failures[1].Clause)
+		}
+
+		result = CheckConditionDetailed(condition, Lookup{MFA: true}, resolver)
+		assert.True(t, result.Allowed)
+		assert.Empty(t, result.Failures)
+
+		result = CheckConditionDetailed(Condition{Expression: ptr(Not(Leaf(Condition{RequireMFA: true})))}, Lookup{MFA: true})
+		assert.Equal(t, []ConditionFailure{{Clause: ConditionClauseNot, Message: "access is excluded by condition", Path: "expression"}}, result.Failures)
+	})
+}
+
+func TestCheckConditionLocations(t *testing.T) {
+	groups := LocationGroupResolver(func(groupID string) []string {
+		return map[string][]string{
+			"WEST-COAST": {"US-CA", "us-or", "US-WA"},
+			"EMEA":       {"EEA", "GB", "ZA"},
+			"LOOP":       {"LOOP", "JP"},
+		}[groupID]
+	})
+
+	testCases := []struct {
+		name      string
+		condition Condition
+		location  string
+		expected  bool
+	}{
+		{"Country matches subdivision", Condition{AllowedLocations: []string{"US"}}, "US-CA", true},
+		{"Subdivision does not match country", Condition{AllowedLocations: []string{"US-CA"}}, "US", false},
+		{"Subdivision does not match sibling", Condition{AllowedLocations: []string{"US-CA"}}, "US-NY", false},
+		{"Case normalised", Condition{AllowedLocations: []string{"gb"}}, "Gb", true},
+		{"Prefix is not a country", Condition{AllowedLocations: []string{"U"}}, "US", false},
+		{"Built-in EU", Condition{AllowedLocations: []string{"EU"}}, "DE-BY", true},
+		{"Built-in EEA includes EU", Condition{AllowedLocations: []string{"EEA"}}, "FR", true},
+		{"Built-in EEA", Condition{AllowedLocations: []string{"EEA"}}, "NO", true},
+		{"Built-in EU excludes EEA only", Condition{AllowedLocations: []string{"EU"}}, "NO", false},
+		{"Workspace group", Condition{AllowedLocations: []string{"west-coast"}}, "US-OR", true},
+		{"Workspace group miss", Condition{AllowedLocations: []string{"WEST-COAST"}}, "US-NY", false},
+		{"Nested groups", Condition{AllowedLocations: []string{"EMEA"}}, "IE", true},
+		{"Group cycle", Condition{AllowedLocations: []string{"LOOP"}}, "JP", true},
+		{"Blocked group", Condition{BlockedLocations: []string{"EU"}}, "PL", false},
+		{"Blocked subdivision", Condition{BlockedLocations: []string{"US-CA"}}, "US-TX", true},
+		{"Empty location not allowed", Condition{AllowedLocations: []string{"US"}}, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, CheckConditionWith(tc.condition, Lookup{GeoLocation: tc.location}, groups))
+		})
+	}
+
+	// Failures name the blocked rule that matched
+	result := CheckConditionDetailed(Condition{BlockedLocations: []string{"US", "EMEA"}}, Lookup{GeoLocation: "ie"}, groups)
+	assert.Equal(t, []ConditionFailure{
+		{Clause: ConditionClauseBlockedLocation, Message: `location "ie" is blocked by EMEA`, Values: []string{"EMEA"}},
+	}, result.Failures)
+
+	assert.True(t, IsLocationCode("US-CA"))
+	assert.False(t, IsLocationCode("USA"))
+	assert.False(t, IsLocationCode("US-"))
+}
+
+func TestCheckConditionPrincipal(t *testing.T) {
+	schema := map[string]MemberAttribute{"grade": {Key: "grade", Type: AttributeTypeNumber}}
+	sales := &Principal{MembershipType: MembershipTypeMember, PartnerID: "bpo-1", Attributes: map[string]any{"department": "sales", "grade": float64(4)}, Schema: schema}
+	support := &Principal{MembershipType: MembershipTypeSupport, Attributes: map[string]any{"department": "support", "grade": float64(2)}, Schema: schema}
+
+	salesOnly := Condition{Principal: []AttributeFilter{{Key: "department", Operator: AttributeEquals, Values: []string{"sales"}}}}
+	partnered := Condition{Principal: []AttributeFilter{{Key: PrincipalKeyPartnerID, Operator: AttributeExists}}}
+	senior := Condition{Principal: []AttributeFilter{{Key: "grade", Operator: AttributeGreaterThan, Values: []string{"3"}}}}
+	supportType := Condition{Principal: []AttributeFilter{{Key: PrincipalKeyMembershipType, Operator: AttributeEquals, Values: []string{"support"}}}}
+	supportOrMFA := Condition{Expression: ptr(AnyOf(Leaf(supportType), Leaf(Condition{RequireMFA: true})))}
+
+	testCases := []struct {
+		name      string
+		condition Condition
+		principal *Principal
+		mfa       bool
+		expected  bool
+	}{
+		{"Department matches", salesOnly, sales, false, true},
+		{"Department differs", salesOnly, support, false, false},
+		{"Partner set", partnered, sales, false, true},
+		{"Partner unset", partnered, support, false, false},
+		{"Numeric attribute", senior, sales, false, true},
+		{"Numeric attribute below", senior, support, false, false},
+		{"Membership type", supportType, support, false, true},
+		{"Expression principal branch", supportOrMFA, support, false, true},
+		{"Expression MFA branch", supportOrMFA, sales, true, true},
+		{"Expression neither", supportOrMFA, sales, false, false},
+		{"No principal", salesOnly, nil, false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookup := Lookup{Principal: tc.principal, MFA: tc.mfa}
+			assert.Equal(t, tc.expected, CheckCondition(tc.condition, lookup))
+		})
+	}
+
+	result := CheckConditionDetailed(salesOnly, Lookup{Principal: support})
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, ConditionClausePrincipal, result.Failures[0].Clause)
+	}
+	assert.True(t, supportOrMFA.RequiresPrincipal())
+	assert.False(t, Condition{RequireMFA: true}.RequiresPrincipal())
+}
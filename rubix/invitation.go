@@ -0,0 +1,36 @@
+package rubix
+
+import "time"
+
+type InvitationState string
+
+const (
+	InvitationStatePending  InvitationState = "pending"
+	InvitationStateAccepted InvitationState = "accepted"
+	InvitationStateRevoked  InvitationState = "revoked"
+)
+
+// Invitation offers membership to an email address. Only a hash of the token is stored,
+// the token itself is returned once when the invitation is created or resent.
+type Invitation struct {
+	Workspace  string          `json:"workspace"`
+	ID         string          `json:"id"`
+	Email      string          `json:"email"`
+	Type       MembershipType  `json:"type"`
+	PartnerID  string          `json:"partnerID"`
+	Roles      []string        `json:"roles"`
+	Teams      []string        `json:"teams"`
+	InvitedBy  string          `json:"invitedBy"`
+	State      InvitationState `json:"state"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	ExpiresAt  time.Time       `json:"expiresAt"`
+	LastSentAt time.Time       `json:"lastSentAt"`
+	SendCount  int             `json:"sendCount"`
+	AcceptedBy string          `json:"acceptedBy"`
+	AcceptedAt time.Time       `json:"acceptedAt"`
+}
+
+// Expired reports whether a pending invitation can no longer be accepted
+func (i Invitation) Expired(at time.Time) bool {
+	return i.State == InvitationStatePending && !at.Before(i.ExpiresAt)
+}
@@ -1,16 +1,25 @@
 package rubix
 
+import "strings"
+
+// IPGroupReferencePrefix marks an entry that includes another group in the same workspace, e.g. "group:office"
+const IPGroupReferencePrefix = "group:"
+
 type IPGroup struct {
 	Workspace   string   `json:"workspace"`
 	ID          string   `json:"id"`
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Source      string   `json:"source"`     // "manual" or "external"
-	Entries     []string `json:"entries"`     // IPs and CIDRs
+	Entries     []string `json:"entries"`     // IPs, CIDRs and group references
 	ExternalURL string   `json:"externalUrl"`
 	JSONPath    string   `json:"jsonPath"`
 	LastSynced  string   `json:"lastSynced"` // RFC3339
 	EntryCount  int      `json:"entryCount"`
+	SyncError   string   `json:"syncError"` // last external sync failure, empty once a sync succeeds
+
+	// CollapseOverlaps merges overlapping and adjacent ranges whenever entries are stored
+	CollapseOverlaps bool `json:"collapseOverlaps"`
 }
 
 type MutateIPGroupPayload struct {
@@ -22,6 +31,9 @@ type MutateIPGroupPayload struct {
 	JSONPath    *string
 	LastSynced  *string
 	EntryCount  *int
+	SyncError   *string
+
+	CollapseOverlaps *bool
 }
 
 type MutateIPGroupOption func(*MutateIPGroupPayload)
@@ -38,6 +50,7 @@ func WithIPGroupSource(source string) MutateIPGroupOption {
 	return func(p *MutateIPGroupPayload) { p.Source = &source }
 }
 
+// WithIPGroupEntries replaces the entries, which are validated and normalised when stored
 func WithIPGroupEntries(entries []string) MutateIPGroupOption {
 	return func(p *MutateIPGroupPayload) {
 		p.Entries = &entries
@@ -57,3 +70,66 @@ func WithIPGroupJSONPath(path string) MutateIPGroupOption {
 func WithIPGroupLastSynced(ts string) MutateIPGroupOption {
 	return func(p *MutateIPGroupPayload) { p.LastSynced = &ts }
 }
+
+func WithIPGroupSyncError(syncErr string) MutateIPGroupOption {
+	return func(p *MutateIPGroupPayload) { p.SyncError = &syncErr }
+}
+
+// WithIPGroupCollapseOverlaps sets whether stored entries are collapsed, enabling it collapses the current entries
+func WithIPGroupCollapseOverlaps(collapse bool) MutateIPGroupOption {
+	return func(p *MutateIPGroupPayload) { p.CollapseOverlaps = &collapse }
+}
+
+// IPGroupReference returns the referenced group ID when the entry is a group reference
+func IPGroupReference(entry string) (string, bool) {
+	id, ok := strings.CutPrefix(strings.TrimSpace(entry), IPGroupReferencePrefix)
+	return strings.TrimSpace(id), ok
+}
+
+// ExpandIPGroupEntries returns the IPs and CIDRs of the group and every group it references.
+// Each group is visited once, so reference cycles terminate, and unknown groups contribute nothing.
+func ExpandIPGroupEntries(groupID string, entries func(groupID string) []string) []string {
+	var ret []string
+	visited := map[string]bool{}
+	var expand func(id string)
+	expand = func(id string) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		for _, entry := range entries(id) {
+			if ref, ok := IPGroupReference(entry); ok {
+				expand(ref)
+			} else {
+				ret = append(ret, entry)
+			}
+		}
+	}
+	expand(groupID)
+	return ret
+}
+
+// IPGroupReferencesCycle reports whether the group can reach itself through its references
+func IPGroupReferencesCycle(groupID string, entries func(groupID string) []string) bool {
+	visited := map[string]bool{}
+	var reaches func(id string) bool
+	reaches = func(id string) bool {
+		for _, entry := range entries(id) {
+			ref, ok := IPGroupReference(entry)
+			if !ok {
+				continue
+			}
+			if ref == groupID {
+				return true
+			}
+			if !visited[ref] {
+				visited[ref] = true
+				if reaches(ref) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return reaches(groupID)
+}
@@ -0,0 +1,219 @@
+package rubix
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPMatcher is a compiled set of IPs and CIDRs, matched with a binary prefix trie
+// so a lookup costs at most one step per address bit regardless of the number of entries.
+type IPMatcher struct {
+	v4      *ipTrieNode
+	v6      *ipTrieNode
+	entries int
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	terminal bool
+}
+
+// NewIPMatcher compiles the entries, entries that are neither an IP nor a CIDR never match
+func NewIPMatcher(entries []string) *IPMatcher {
+	m := &IPMatcher{v4: &ipTrieNode{}, v6: &ipTrieNode{}}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, ok := IPGroupReference(entry); ok {
+			continue // references are expanded before compiling
+		}
+		m.entries++
+		if ip, bits, ok := parseIPPrefix(entry); ok {
+			m.insert(ip, bits)
+		}
+	}
+	return m
+}
+
+// Len returns the number of entries the matcher was compiled from
+func (m *IPMatcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.entries
+}
+
+func (m *IPMatcher) Contains(ip net.IP) bool {
+	if m == nil || ip == nil {
+		return false
+	}
+	node := m.v6
+	if v4 := ip.To4(); v4 != nil {
+		ip, node = v4, m.v4
+	} else if ip = ip.To16(); ip == nil {
+		return false
+	}
+	for i := 0; ; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		if node = node.children[ipBit(ip, i)]; node == nil {
+			return false
+		}
+	}
+}
+
+func (m *IPMatcher) insert(ip net.IP, bits int) {
+	node := m.v6
+	if len(ip) == net.IPv4len {
+		node = m.v4
+	}
+	for i := 0; i < bits; i++ {
+		if node.terminal {
+			return // already covered by a shorter prefix
+		}
+		b := ipBit(ip, i)
+		if node.children[b] == nil {
+			node.children[b] = &ipTrieNode{}
+		}
+		node = node.children[b]
+	}
+	node.terminal = true
+	node.children = [2]*ipTrieNode{}
+}
+
+// ValidIPEntry reports whether the entry is an IP address or CIDR
+func ValidIPEntry(entry string) bool {
+	_, _, ok := parseIPPrefix(strings.TrimSpace(entry))
+	return ok
+}
+
+// NormalizeIPEntries validates and canonicalises entries, e.g. "10.1.2.3/8" becomes "10.0.0.0/8",
+// IPv4-mapped IPv6 becomes IPv4 and host prefixes become plain IPs. Blank entries and duplicates are dropped,
+// group references are kept as they are.
+// Every malformed entry is listed in the returned ErrInvalidIPEntry error.
+func NormalizeIPEntries(entries []string) ([]string, error) {
+	ret := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	var invalid []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ref, ok := IPGroupReference(entry); ok {
+			if ref == "" {
+				invalid = append(invalid, fmt.Sprintf("%q", entry))
+			} else if canonical := IPGroupReferencePrefix + ref; !seen[canonical] {
+				seen[canonical] = true
+				ret = append(ret, canonical)
+			}
+			continue
+		}
+		ip, bits, ok := parseIPPrefix(entry)
+		if !ok {
+			invalid = append(invalid, fmt.Sprintf("%q", entry))
+			continue
+		}
+		if canonical := formatIPPrefix(ip, bits); !seen[canonical] {
+			seen[canonical] = true
+			ret = append(ret, canonical)
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIPEntry, strings.Join(invalid, ", "))
+	}
+	return ret, nil
+}
+
+// CollapseIPEntries returns the smallest set of prefixes covering the entries, sorted by address.
+// Entries within a wider range are dropped and adjacent ranges merged, e.g. two /25s into a /24.
+// Group references are kept, after the prefixes.
+func CollapseIPEntries(entries []string) []string {
+	m := NewIPMatcher(entries)
+	var ret, refs []string
+	for _, entry := range entries {
+		if _, ok := IPGroupReference(entry); ok {
+			refs = append(refs, entry)
+		}
+	}
+	for _, root := range []struct {
+		node *ipTrieNode
+		size int
+	}{{m.v4, net.IPv4len}, {m.v6, net.IPv6len}} {
+		root.node.merge()
+		root.node.walk(make(net.IP, root.size), 0, &ret)
+	}
+	return append(ret, refs...)
+}
+
+// merge marks a node terminal when both halves of its range are covered
+func (n *ipTrieNode) merge() {
+	if n.terminal {
+		return
+	}
+	for _, child := range n.children {
+		if child != nil {
+			child.merge()
+		}
+	}
+	if n.children[0] != nil && n.children[1] != nil && n.children[0].terminal && n.children[1].terminal {
+		n.terminal = true
+		n.children = [2]*ipTrieNode{}
+	}
+}
+
+func (n *ipTrieNode) walk(ip net.IP, depth int, out *[]string) {
+	if n.terminal {
+		*out = append(*out, formatIPPrefix(ip, depth))
+		return
+	}
+	for b, child := range n.children {
+		if child == nil {
+			continue
+		}
+		ip[depth/8] |= byte(b) << (7 - depth%8)
+		child.walk(ip, depth+1, out)
+		ip[depth/8] &^= 1 << (7 - depth%8)
+	}
+}
+
+func formatIPPrefix(ip net.IP, bits int) string {
+	if bits == len(ip)*8 {
+		return ip.String()
+	}
+	return fmt.Sprintf("%s/%d", ip, bits)
+}
+
+func ipBit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
+
+// parseIPPrefix returns the network address, in its 4 or 16 byte form, and prefix length for an IP or CIDR
+func parseIPPrefix(entry string) (net.IP, int, bool) {
+	if strings.Contains(entry, "/") {
+		_, cidr, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, 0, false
+		}
+		bits, total := cidr.Mask.Size()
+		if v4 := cidr.IP.To4(); v4 != nil && total == 8*net.IPv6len && bits >= 96 {
+			return v4, bits - 96, true // IPv4-mapped IPv6 network
+		}
+		return cidr.IP, bits, true
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, 0, false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4, 8 * net.IPv4len, true
+	}
+	return ip, 8 * net.IPv6len, true
+}
@@ -0,0 +1,117 @@
+package rubix
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPMatcher(t *testing.T) {
+	m := NewIPMatcher([]string{"1.1.1.1", "10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32", "::ffff:192.168.0.0/112", "not-an-ip", ""})
+	assert.Equal(t, 6, m.Len())
+
+	testCases := []struct {
+		ip       string
+		expected bool
+	}{
+		{"1.1.1.1", true},
+		{"1.1.1.2", false},
+		{"10.255.0.1", true},
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"::ffff:10.0.0.1", true},
+		{"192.168.4.4", true},
+		{"192.169.0.1", false},
+		{"2001:db8:1::1", true},
+		{"2001:db9::1", false},
+		{"::1", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.expected, m.Contains(net.ParseIP(tc.ip)))
+		})
+	}
+
+	assert.False(t, m.Contains(nil))
+	assert.True(t, NewIPMatcher([]string{"0.0.0.0/0"}).Contains(net.ParseIP("8.8.8.8")))
+	assert.False(t, NewIPMatcher([]string{"0.0.0.0/0"}).Contains(net.ParseIP("2001:db8::1")))
+
+	var empty *IPMatcher
+	assert.Equal(t, 0, empty.Len())
+	assert.False(t, empty.Contains(net.ParseIP("1.1.1.1")))
+}
+
+func TestCheckConditionCompiledIPGroups(t *testing.T) {
+	groups := CompiledIPGroups{"office": NewIPMatcher([]string{"10.0.0.0/8"}), "invalid": NewIPMatcher([]string{"nope"})}
+
+	assert.True(t, CheckConditionWith(Condition{AllowedIPGroups: []string{"office"}}, Lookup{IpAddress: net.ParseIP("10.2.3.4")}, groups))
+	assert.False(t, CheckConditionWith(Condition{AllowedIPGroups: []string{"office"}}, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, groups))
+	assert.False(t, CheckConditionWith(Condition{BlockedIPGroups: []string{"office"}}, Lookup{IpAddress: net.ParseIP("10.2.3.4")}, groups))
+	assert.True(t, CheckConditionWith(Condition{AllowedIPGroups: []string{"unknown"}}, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, groups))
+	assert.False(t, CheckConditionWith(Condition{AllowedIPGroups: []string{"invalid"}}, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, groups))
+}
+
+func TestNormalizeIPEntries(t *testing.T) {
+	entries, err := NormalizeIPEntries([]string{
+		" 10.1.2.3/8 ", "10.0.0.0/8", "1.1.1.1/32", "1.1.1.1", "::ffff:2.2.2.2", "::ffff:192.168.0.0/112",
+		"2001:0db8:0000::1", "2001:db8:ffff::/32", "",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "1.1.1.1", "2.2.2.2", "192.168.0.0/16", "2001:db8::1", "2001:db8::/32"}, entries)
+
+	_, err = NormalizeIPEntries([]string{"1.1.1.1", "1.1.1.256", "10.0.0.0/33", "office"})
+	assert.ErrorIs(t, err, ErrInvalidIPEntry)
+	assert.EqualError(t, err, `invalid IP or CIDR: "1.1.1.256", "10.0.0.0/33", "office"`)
+}
+
+func TestCollapseIPEntries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entries  []string
+		expected []string
+	}{
+		{"Contained ranges dropped", []string{"10.1.0.0/16", "10.0.0.0/8", "10.2.3.4"}, []string{"10.0.0.0/8"}},
+		{"Adjacent halves merged", []string{"192.168.0.128/25", "192.168.0.0/25"}, []string{"192.168.0.0/24"}},
+		{"Merges cascade", []string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25"}, []string{"10.0.0.0/24"}},
+		{"Adjacent hosts merged", []string{"1.1.1.1", "1.1.1.0"}, []string{"1.1.1.0/31"}},
+		{"Non-sibling ranges kept", []string{"10.0.0.128/25", "10.0.1.0/25"}, []string{"10.0.0.128/25", "10.0.1.0/25"}},
+		{"Sorted with IPv4 before IPv6", []string{"2001:db8::/32", "2001:db8:1::/48", "9.9.9.9", "1.1.1.1"}, []string{"1.1.1.1", "9.9.9.9", "2001:db8::/32"}},
+		{"Everything", []string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/0"}},
+		{"Empty", nil, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, CollapseIPEntries(tc.entries))
+		})
+	}
+}
+
+func TestNestedIPGroups(t *testing.T) {
+	resolver := mockResolver(map[string][]string{
+		"corporate":  {"group:office", "group:vpn", "group:datacentre"},
+		"office":     {"1.1.1.1"},
+		"vpn":        {"10.0.0.0/8", "group:corporate"},
+		"datacentre": {"group:missing"},
+		"self":       {"group:self", "2.2.2.2"},
+	})
+
+	assert.True(t, CheckCondition(Condition{AllowedIPGroups: []string{"corporate"}}, Lookup{IpAddress: net.ParseIP("1.1.1.1")}, resolver))
+	assert.True(t, CheckCondition(Condition{AllowedIPGroups: []string{"corporate"}}, Lookup{IpAddress: net.ParseIP("10.9.9.9")}, resolver))
+	assert.False(t, CheckCondition(Condition{AllowedIPGroups: []string{"corporate"}}, Lookup{IpAddress: net.ParseIP("8.8.8.8")}, resolver))
+	assert.True(t, CheckCondition(Condition{AllowedIPGroups: []string{"vpn"}}, Lookup{IpAddress: net.ParseIP("1.1.1.1")}, resolver), "cycle resolves to the union")
+	assert.False(t, CheckCondition(Condition{BlockedIPGroups: []string{"self"}}, Lookup{IpAddress: net.ParseIP("2.2.2.2")}, resolver))
+
+	lookup := func(id string) []string { return resolver(id) }
+	assert.True(t, IPGroupReferencesCycle("vpn", lookup))
+	assert.True(t, IPGroupReferencesCycle("self", lookup))
+	assert.False(t, IPGroupReferencesCycle("office", lookup))
+	assert.False(t, IPGroupReferencesCycle("datacentre", lookup))
+
+	entries, err := NormalizeIPEntries([]string{" group:office ", "group:office", "1.1.1.1/32"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group:office", "1.1.1.1"}, entries)
+	_, err = NormalizeIPEntries([]string{"group:"})
+	assert.ErrorIs(t, err, ErrInvalidIPEntry)
+	assert.Equal(t, []string{"10.0.0.0/8", "group:office"}, CollapseIPEntries([]string{"group:office", "10.1.0.0/16", "10.0.0.0/8"}))
+}
@@ -0,0 +1,110 @@
+package rubix
+
+import (
+	"regexp"
+	"strings"
+)
+
+// LocationGroup is a workspace defined set of locations, referenced by ID in condition location lists
+type LocationGroup struct {
+	Workspace   string   `json:"workspace"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Locations   []string `json:"locations"` // country codes, subdivision codes (US-CA) or other group IDs
+}
+
+type MutateLocationGroupPayload struct {
+	Name        *string
+	Description *string
+	Locations   *[]string
+}
+
+type MutateLocationGroupOption func(*MutateLocationGroupPayload)
+
+func WithLocationGroupName(name string) MutateLocationGroupOption {
+	return func(p *MutateLocationGroupPayload) { p.Name = &name }
+}
+
+func WithLocationGroupDescription(desc string) MutateLocationGroupOption {
+	return func(p *MutateLocationGroupPayload) { p.Description = &desc }
+}
+
+func WithLocationGroupLocations(locations []string) MutateLocationGroupOption {
+	return func(p *MutateLocationGroupPayload) { p.Locations = &locations }
+}
+
+var euCountries = []string{
+	"AT", "BE", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "GR", "HU", "IE",
+	"IT", "LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE",
+}
+
+// BuiltinLocationGroups are available in every workspace, a workspace group with the same ID takes precedence
+var BuiltinLocationGroups = map[string][]string{
+	"EU":  euCountries,
+	"EEA": {"EU", "IS", "LI", "NO"},
+}
+
+var locationCodePattern = regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`)
+
+// IsLocationCode reports whether the normalised location is an ISO 3166-1 alpha-2 country code
+// or an ISO 3166-2 subdivision code, e.g. "US" or "US-CA"
+func IsLocationCode(location string) bool {
+	return locationCodePattern.MatchString(location)
+}
+
+// NormalizeLocation upper-cases a location code, e.g. "us-ca" becomes "US-CA"
+func NormalizeLocation(location string) string {
+	return strings.ToUpper(strings.TrimSpace(location))
+}
+
+// locationMatches reports whether the location falls within any of the rules.
+// A rule is a group ID, a country code matching the country and its subdivisions, or a subdivision code.
+func locationMatches(rules []string, location string, resolvers []ConditionResolver) bool {
+	_, ok := matchLocation(rules, location, resolvers)
+	return ok
+}
+
+// matchLocation returns the rule that contains the location, a group ID when matched through a group
+func matchLocation(rules []string, location string, resolvers []ConditionResolver) (string, bool) {
+	location = NormalizeLocation(location)
+	if location == "" {
+		return "", false
+	}
+	visited := map[string]bool{}
+	var match func(rules []string) bool
+	match = func(rules []string) bool {
+		for _, rule := range rules {
+			rule = NormalizeLocation(rule)
+			if rule == "" || visited[rule] {
+				continue
+			}
+			visited[rule] = true
+			if location == rule || strings.HasPrefix(location, rule+"-") {
+				return true
+			}
+			if members := resolveLocationGroup(rule, resolvers); members != nil && match(members) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range rules {
+		if match([]string{rule}) {
+			return NormalizeLocation(rule), true
+		}
+	}
+	return "", false
+}
+
+func resolveLocationGroup(groupID string, resolvers []ConditionResolver) []string {
+	for _, r := range resolvers {
+		if r == nil {
+			continue
+		}
+		if members := r.LocationGroup(groupID); members != nil {
+			return members
+		}
+	}
+	return BuiltinLocationGroups[groupID]
+}
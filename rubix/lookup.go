@@ -16,6 +16,8 @@ type Lookup struct {
 	MFA             bool
 	VerifiedAccount bool
 	SessionIssued   time.Time
+	RequestTime     time.Time  // Time conditions are evaluated at, zero for the current time
+	Principal       *Principal // Membership details for principal conditions, always loaded by the provider
 }
 
 type DataResult struct {
@@ -46,6 +48,14 @@ func NewLookup(WorkspaceUUID, UserUUID string, AppID app.GlobalAppID, geoLocatio
 	}
 }
 
+// Now returns the time conditions are evaluated at
+func (l Lookup) Now() time.Time {
+	if l.RequestTime.IsZero() {
+		return time.Now()
+	}
+	return l.RequestTime
+}
+
 func (l Lookup) String() string {
 	return l.WorkspaceUUID + "---" + l.UserUUID + "---" + l.AppID.VendorID + "---" + l.AppID.AppID
 }
@@ -0,0 +1,17 @@
+package rubix
+
+// PendingMember is a membership awaiting approval, with the approval mode resolved for their email domain
+type PendingMember struct {
+	Membership
+	Domain       string `json:"domain"`
+	ApprovalMode string `json:"approvalMode"`
+}
+
+// MemberApprovalDefaults are the roles and teams given to a member when approved.
+// An empty Domain applies to every member without domain specific defaults.
+type MemberApprovalDefaults struct {
+	Workspace string   `json:"workspace"`
+	Domain    string   `json:"domain"`
+	Roles     []string `json:"roles"`
+	Teams     []string `json:"teams"`
+}
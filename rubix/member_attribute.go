@@ -0,0 +1,254 @@
+package rubix
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AttributeType string
+
+const (
+	AttributeTypeString AttributeType = "string"
+	AttributeTypeNumber AttributeType = "number" // float64
+	AttributeTypeBool   AttributeType = "bool"
+	AttributeTypeDate   AttributeType = "date" // time.Time, stored as YYYY-MM-DD
+	AttributeTypeEnum   AttributeType = "enum" // string limited to Options
+)
+
+const attributeDateLayout = time.DateOnly
+
+// MemberAttribute defines a custom attribute members of a workspace can carry, e.g. employee number or cost centre
+type MemberAttribute struct {
+	Workspace   string        `json:"workspace"`
+	Key         string        `json:"key"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Type        AttributeType `json:"type"`
+	Options     []string      `json:"options,omitempty"` // Allowed values for enum attributes
+}
+
+func (a MemberAttribute) Validate() error {
+	if a.Key == "" {
+		return errors.New("attribute key is required")
+	}
+	if isPrincipalKey(a.Key) {
+		return fmt.Errorf("attribute keys starting with %q are reserved", PrincipalKeyPrefix)
+	}
+	switch a.Type {
+	case AttributeTypeString, AttributeTypeNumber, AttributeTypeBool, AttributeTypeDate:
+	case AttributeTypeEnum:
+		if len(a.Options) == 0 {
+			return errors.New("enum attribute requires options")
+		}
+	default:
+		return fmt.Errorf("invalid attribute type %q", a.Type)
+	}
+	return nil
+}
+
+// Parse converts a stored or user supplied string into the typed value
+func (a MemberAttribute) Parse(raw string) (any, error) {
+	raw = strings.TrimSpace(raw)
+	switch a.Type {
+	case AttributeTypeNumber:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid number %q", a.Key, raw)
+		}
+		return v, nil
+	case AttributeTypeBool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid boolean %q", a.Key, raw)
+		}
+		return v, nil
+	case AttributeTypeDate:
+		v, err := time.Parse(attributeDateLayout, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid date %q, expected YYYY-MM-DD", a.Key, raw)
+		}
+		return v, nil
+	case AttributeTypeEnum:
+		if !slices.Contains(a.Options, raw) {
+			return nil, fmt.Errorf("%s: %q is not one of %s", a.Key, raw, strings.Join(a.Options, ", "))
+		}
+		return raw, nil
+	default:
+		return raw, nil
+	}
+}
+
+// Format validates a typed value, or a string to parse, and returns its stored form
+func (a MemberAttribute) Format(value any) (string, error) {
+	if s, ok := value.(string); ok {
+		parsed, err := a.Parse(s)
+		if err != nil {
+			return "", err
+		}
+		value = parsed
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		if a.Type == AttributeTypeNumber {
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	case int:
+		if a.Type == AttributeTypeNumber {
+			return strconv.Itoa(v), nil
+		}
+	case bool:
+		if a.Type == AttributeTypeBool {
+			return strconv.FormatBool(v), nil
+		}
+	case time.Time:
+		if a.Type == AttributeTypeDate {
+			return v.Format(attributeDateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("%s: %T is not a valid %s value", a.Key, value, a.Type)
+}
+
+type AttributeOperator string
+
+const (
+	AttributeEquals      AttributeOperator = "eq"
+	AttributeNotEquals   AttributeOperator = "neq"
+	AttributeIn          AttributeOperator = "in"
+	AttributeExists      AttributeOperator = "exists"
+	AttributeMissing     AttributeOperator = "missing"
+	AttributeGreaterThan AttributeOperator = "gt"
+	AttributeLessThan    AttributeOperator = "lt"
+)
+
+// AttributeFilter matches members by a custom attribute. Values are compared in the attribute's type,
+// gt and lt apply to numbers, dates and strings.
+type AttributeFilter struct {
+	Key      string            `json:"key"`
+	Operator AttributeOperator `json:"op"`
+	Values   []string          `json:"values,omitempty"`
+}
+
+func (f AttributeFilter) Validate() error {
+	if f.Key == "" {
+		return errors.New("attribute key is required")
+	}
+	switch f.Operator {
+	case AttributeExists, AttributeMissing:
+		if len(f.Values) > 0 {
+			return fmt.Errorf("%s takes no values", f.Operator)
+		}
+	case AttributeEquals, AttributeNotEquals, AttributeIn:
+		if len(f.Values) == 0 {
+			return fmt.Errorf("%s requires a value", f.Operator)
+		}
+	case AttributeGreaterThan, AttributeLessThan:
+		if len(f.Values) != 1 {
+			return fmt.Errorf("%s requires exactly one value", f.Operator)
+		}
+	default:
+		return fmt.Errorf("invalid attribute operator %q", f.Operator)
+	}
+	return nil
+}
+
+// MatchAttributes reports whether the attributes satisfy every filter
+func MatchAttributes(attributes map[string]any, schema map[string]MemberAttribute, filters ...AttributeFilter) bool {
+	for _, filter := range filters {
+		if !filter.Match(attributes, schema) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f AttributeFilter) Match(attributes map[string]any, schema map[string]MemberAttribute) bool {
+	value, has := attributes[f.Key]
+	switch f.Operator {
+	case AttributeExists:
+		return has
+	case AttributeMissing:
+		return !has
+	}
+	if !has {
+		return f.Operator == AttributeNotEquals
+	}
+
+	def, ok := schema[f.Key]
+	if !ok {
+		def = MemberAttribute{Key: f.Key, Type: AttributeTypeString}
+	}
+	compare := func(raw string) (int, bool) {
+		want, err := def.Parse(raw)
+		if err != nil {
+			return 0, false
+		}
+		return compareAttributeValues(value, want)
+	}
+
+	switch f.Operator {
+	case AttributeEquals, AttributeIn:
+		for _, raw := range f.Values {
+			if c, ok := compare(raw); ok && c == 0 {
+				return true
+			}
+		}
+		return false
+	case AttributeNotEquals:
+		for _, raw := range f.Values {
+			if c, ok := compare(raw); ok && c == 0 {
+				return false
+			}
+		}
+		return true
+	case AttributeGreaterThan, AttributeLessThan:
+		if len(f.Values) != 1 {
+			return false
+		}
+		c, ok := compare(f.Values[0])
+		if !ok || def.Type == AttributeTypeBool {
+			return false
+		}
+		if f.Operator == AttributeGreaterThan {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+func compareAttributeValues(a, b any) (int, bool) {
+	switch av := a.(type) {
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1, true
+			case av > bv:
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			if av == bv {
+				return 0, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Compare(bv), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,89 @@
+package rubix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemberAttributeFormat(t *testing.T) {
+	cases := []struct {
+		attr    MemberAttribute
+		value   any
+		want    string
+		wantErr bool
+	}{
+		{MemberAttribute{Key: "n", Type: AttributeTypeNumber}, 42, "42", false},
+		{MemberAttribute{Key: "n", Type: AttributeTypeNumber}, "12.5", "12.5", false},
+		{MemberAttribute{Key: "n", Type: AttributeTypeNumber}, "twelve", "", true},
+		{MemberAttribute{Key: "b", Type: AttributeTypeBool}, true, "true", false},
+		{MemberAttribute{Key: "b", Type: AttributeTypeBool}, 1, "", true},
+		{MemberAttribute{Key: "d", Type: AttributeTypeDate}, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), "2024-03-01", false},
+		{MemberAttribute{Key: "d", Type: AttributeTypeDate}, "01/03/2024", "", true},
+		{MemberAttribute{Key: "e", Type: AttributeTypeEnum, Options: []string{"sales", "support"}}, "sales", "sales", false},
+		{MemberAttribute{Key: "e", Type: AttributeTypeEnum, Options: []string{"sales", "support"}}, "finance", "", true},
+		{MemberAttribute{Key: "s", Type: AttributeTypeString}, "  CC-100 ", "CC-100", false},
+	}
+	for _, c := range cases {
+		got, err := c.attr.Format(c.value)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("%s Format(%v) = %q, %v; want %q, error %v", c.attr.Type, c.value, got, err, c.want, c.wantErr)
+		}
+	}
+
+	if err := (MemberAttribute{Key: "e", Type: AttributeTypeEnum}).Validate(); err == nil {
+		t.Errorf("expected enum without options to be invalid")
+	}
+	if err := (MemberAttribute{Key: "x", Type: "colour"}).Validate(); err == nil {
+		t.Errorf("expected unknown type to be invalid")
+	}
+}
+
+func TestMatchAttributes(t *testing.T) {
+	schema := map[string]MemberAttribute{
+		"department": {Key: "department", Type: AttributeTypeString},
+		"grade":      {Key: "grade", Type: AttributeTypeNumber},
+		"hired":      {Key: "hired", Type: AttributeTypeDate},
+		"remote":     {Key: "remote", Type: AttributeTypeBool},
+	}
+	attrs := map[string]any{
+		"department": "sales",
+		"grade":      float64(3),
+		"hired":      time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		"remote":     true,
+	}
+
+	cases := []struct {
+		name   string
+		filter AttributeFilter
+		want   bool
+	}{
+		{"eq", AttributeFilter{Key: "department", Operator: AttributeEquals, Values: []string{"sales"}}, true},
+		{"eq mismatch", AttributeFilter{Key: "department", Operator: AttributeEquals, Values: []string{"support"}}, false},
+		{"in", AttributeFilter{Key: "department", Operator: AttributeIn, Values: []string{"support", "sales"}}, true},
+		{"neq", AttributeFilter{Key: "department", Operator: AttributeNotEquals, Values: []string{"support"}}, true},
+		{"neq missing", AttributeFilter{Key: "costCentre", Operator: AttributeNotEquals, Values: []string{"x"}}, true},
+		{"number eq", AttributeFilter{Key: "grade", Operator: AttributeEquals, Values: []string{"3.0"}}, true},
+		{"number gt", AttributeFilter{Key: "grade", Operator: AttributeGreaterThan, Values: []string{"2"}}, true},
+		{"number lt", AttributeFilter{Key: "grade", Operator: AttributeLessThan, Values: []string{"2"}}, false},
+		{"date lt", AttributeFilter{Key: "hired", Operator: AttributeLessThan, Values: []string{"2024-01-01"}}, true},
+		{"bool", AttributeFilter{Key: "remote", Operator: AttributeEquals, Values: []string{"true"}}, true},
+		{"bool gt", AttributeFilter{Key: "remote", Operator: AttributeGreaterThan, Values: []string{"false"}}, false},
+		{"exists", AttributeFilter{Key: "grade", Operator: AttributeExists}, true},
+		{"missing", AttributeFilter{Key: "costCentre", Operator: AttributeMissing}, true},
+		{"eq on missing", AttributeFilter{Key: "costCentre", Operator: AttributeEquals, Values: []string{"x"}}, false},
+		{"invalid value", AttributeFilter{Key: "grade", Operator: AttributeEquals, Values: []string{"three"}}, false},
+	}
+	for _, c := range cases {
+		if got := MatchAttributes(attrs, schema, c.filter); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	both := []AttributeFilter{
+		{Key: "department", Operator: AttributeEquals, Values: []string{"sales"}},
+		{Key: "grade", Operator: AttributeGreaterThan, Values: []string{"5"}},
+	}
+	if MatchAttributes(attrs, schema, both...) {
+		t.Errorf("expected all filters to be required")
+	}
+}
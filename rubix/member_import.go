@@ -0,0 +1,139 @@
+package rubix
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MemberImportRow is a single member to create or update in a bulk import
+type MemberImportRow struct {
+	UserID    string         `json:"userID"`
+	Name      string         `json:"name"`
+	Email     string         `json:"email"`
+	Type      MembershipType `json:"type"`
+	PartnerID string         `json:"partnerID"`
+	Roles     []string       `json:"roles"`
+	Teams     []string       `json:"teams"`
+}
+
+type MemberImportStatus string
+
+const (
+	MemberImportStatusCreated  MemberImportStatus = "created"  // New membership
+	MemberImportStatusUpdated  MemberImportStatus = "updated"  // Existing membership, type, partner, roles and teams applied
+	MemberImportStatusInactive MemberImportStatus = "inactive" // Existing membership that is not active, applied without granting access
+	MemberImportStatusInvalid  MemberImportStatus = "invalid"  // Failed validation, nothing was imported
+	MemberImportStatusFailed   MemberImportStatus = "failed"   // Batch containing the row was rolled back
+	MemberImportStatusSkipped  MemberImportStatus = "skipped"  // Valid, but not imported because other rows were invalid
+)
+
+// MemberImportResult reports the outcome of a row, Row is the 1-based position in the import
+type MemberImportResult struct {
+	Row    int                `json:"row"`
+	UserID string             `json:"userID"`
+	Status MemberImportStatus `json:"status"`
+	Reason string             `json:"reason,omitempty"` // why an inactive row does not grant access
+	Errors []string           `json:"errors,omitempty"`
+}
+
+type MemberImportReport struct {
+	Workspace string               `json:"workspace"`
+	Results   []MemberImportResult `json:"results"`
+}
+
+// Count returns the number of rows with the status
+func (r MemberImportReport) Count(status MemberImportStatus) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Imported returns the number of rows applied, including those for inactive memberships
+func (r MemberImportReport) Imported() int {
+	return r.Count(MemberImportStatusCreated) + r.Count(MemberImportStatusUpdated) + r.Count(MemberImportStatusInactive)
+}
+
+// Succeeded reports whether every row was imported
+func (r MemberImportReport) Succeeded() bool {
+	return r.Imported() == len(r.Results)
+}
+
+// MemberImportColumns are the CSV header names, roles and teams are separated by semicolons
+var MemberImportColumns = []string{"user_id", "name", "email", "type", "partner_id", "roles", "teams"}
+
+// ReadMemberImportCSV reads rows from CSV with a header line. Columns may be in any order,
+// user_id is required and unknown columns are ignored.
+func ReadMemberImportCSV(r io.Reader) ([]MemberImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["user_id"]; !ok {
+		return nil, errors.New("member import requires a user_id column")
+	}
+
+	var rows []MemberImportRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, MemberImportRow{
+			UserID:    field("user_id"),
+			Name:      field("name"),
+			Email:     field("email"),
+			Type:      MembershipType(strings.ToLower(field("type"))),
+			PartnerID: field("partner_id"),
+			Roles:     splitList(field("roles")),
+			Teams:     splitList(field("teams")),
+		})
+	}
+	return rows, nil
+}
+
+// ReadMemberImportJSON reads rows from a JSON array of MemberImportRow
+func ReadMemberImportJSON(r io.Reader) ([]MemberImportRow, error) {
+	var rows []MemberImportRow
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("member import: %w", err)
+	}
+	return rows, nil
+}
+
+func splitList(in string) []string {
+	var out []string
+	for _, v := range strings.Split(in, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
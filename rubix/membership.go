@@ -73,6 +73,8 @@ const (
 	MembershipSourceOIDC        MembershipSource = "oidc"
 	MembershipSourceSCIM        MembershipSource = "scim"
 	MembershipSourceSelfRequest MembershipSource = "self_request"
+	MembershipSourceInvite      MembershipSource = "invite"
+	MembershipSourceImport      MembershipSource = "import"
 )
 
 type Membership struct {
@@ -86,4 +88,40 @@ type Membership struct {
 	State      MembershipState
 	StateSince time.Time
 	Source     MembershipSource
+
+	// Global profile of the user
+	UserProfile
+
+	// Approval decision, set when a pending membership is approved or rejected
+	DecidedBy      string
+	DecidedAt      time.Time
+	DecisionReason string
+
+	// Optional end of the membership, zero for memberships that do not expire
+	ExpiresAt    time.Time
+	ExpiryAction MembershipExpiryAction
+
+	// Custom attributes keyed by MemberAttribute.Key, typed by the workspace schema
+	Attributes map[string]any
+}
+
+// Expired reports whether the membership has passed its expiry
+func (m Membership) Expired(at time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !at.Before(m.ExpiresAt)
+}
+
+// MembershipExpiryAction is applied to a membership once it expires
+type MembershipExpiryAction string
+
+const (
+	MembershipExpirySuspend MembershipExpiryAction = "suspend"
+	MembershipExpiryRemove  MembershipExpiryAction = "remove"
+)
+
+// State returns the membership state applied on expiry
+func (a MembershipExpiryAction) State() MembershipState {
+	if a == MembershipExpiryRemove {
+		return MembershipStateRemoved
+	}
+	return MembershipStateSuspended
 }
@@ -0,0 +1,94 @@
+package rubix
+
+import "time"
+
+type MembershipChangeKind string
+
+const (
+	MembershipChangeJoined MembershipChangeKind = "joined" // Membership created or re-added after removal
+	MembershipChangeState  MembershipChangeKind = "state"
+	MembershipChangeType   MembershipChangeKind = "type"
+	MembershipChangeExpiry MembershipChangeKind = "expiry"
+)
+
+// MembershipChange is a single entry in a member's timeline
+type MembershipChange struct {
+	Workspace  string               `json:"workspace"`
+	User       string               `json:"user"`
+	Kind       MembershipChangeKind `json:"kind"`
+	FromState  MembershipState      `json:"fromState"`
+	ToState    MembershipState      `json:"toState"`
+	FromType   MembershipType       `json:"fromType"`
+	ToType     MembershipType       `json:"toType"`
+	FromExpiry time.Time            `json:"fromExpiry,omitempty"`
+	ToExpiry   time.Time            `json:"toExpiry,omitempty"`
+	Actor      string               `json:"actor"`
+	Reason     string               `json:"reason"`
+	At         time.Time            `json:"at"`
+}
+
+type MembershipChangeMeta struct {
+	Actor  string
+	Reason string
+}
+
+type MembershipChangeOption func(*MembershipChangeMeta)
+
+func WithChangeActor(actor string) MembershipChangeOption {
+	return func(m *MembershipChangeMeta) {
+		m.Actor = actor
+	}
+}
+
+func WithChangeReason(reason string) MembershipChangeOption {
+	return func(m *MembershipChangeMeta) {
+		m.Reason = reason
+	}
+}
+
+func ApplyMembershipChangeOptions(options ...MembershipChangeOption) MembershipChangeMeta {
+	meta := MembershipChangeMeta{}
+	for _, opt := range options {
+		opt(&meta)
+	}
+	return meta
+}
+
+// MembershipTimeline is a member's changes, oldest first
+type MembershipTimeline []MembershipChange
+
+// StateAt returns the membership state at the given time, false if the user was not yet a member
+func (t MembershipTimeline) StateAt(at time.Time) (MembershipState, bool) {
+	state, found := MembershipStatePending, false
+	for _, change := range t {
+		if change.At.After(at) {
+			break
+		}
+		if change.Kind == MembershipChangeJoined || change.Kind == MembershipChangeState {
+			state, found = change.ToState, true
+		}
+	}
+	return state, found
+}
+
+// TimeInState totals how long the member spent in the state, counting an open period up to now
+func (t MembershipTimeline) TimeInState(state MembershipState, now time.Time) time.Duration {
+	var total time.Duration
+	var enteredAt time.Time
+	inState := false
+	for _, change := range t {
+		if change.Kind != MembershipChangeJoined && change.Kind != MembershipChangeState {
+			continue
+		}
+		if inState && change.ToState != state {
+			total += change.At.Sub(enteredAt)
+			inState = false
+		} else if !inState && change.ToState == state {
+			enteredAt, inState = change.At, true
+		}
+	}
+	if inState && now.After(enteredAt) {
+		total += now.Sub(enteredAt)
+	}
+	return total
+}
@@ -7,4 +7,14 @@ import (
 var (
 	ErrNoResultFound = errors.New("no result found")
 	ErrDuplicate     = errors.New("already exists")
+
+	ErrRoleInheritanceCycle = errors.New("role inheritance cycle")
+	ErrPermissionDenied     = errors.New("permission denied")
+	ErrAlreadyDecided       = errors.New("already decided")
+	ErrInvalidIPEntry       = errors.New("invalid IP or CIDR")
+	ErrIPGroupCycle         = errors.New("IP group reference cycle")
+	ErrIPGroupInUse         = errors.New("IP group is referenced by other groups")
+	ErrInvitationExpired    = errors.New("invitation expired")
+	ErrInvitationInvalid    = errors.New("invitation is no longer valid")
+	ErrInvitationRecipient  = errors.New("invitation was sent to another email address")
 )
@@ -0,0 +1,30 @@
+package rubix
+
+import "time"
+
+// OffboardingReport lists everything removed when a user was offboarded from a workspace
+type OffboardingReport struct {
+	Workspace string    `json:"workspace"`
+	User      string    `json:"user"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason"`
+	At        time.Time `json:"at"`
+
+	PreviousState     MembershipState `json:"previousState"`
+	MembershipRemoved bool            `json:"membershipRemoved"`
+
+	Roles           []UserRole `json:"roles"`
+	Teams           []UserTeam `json:"teams"`
+	BPOs            []string   `json:"bpos"`            // BPOs the user managed
+	Statuses        []string   `json:"statuses"`        // Status IDs cleared
+	AuthData        []string   `json:"authData"`        // vendor/app/key of user-scoped auth data
+	ActivationSteps []string   `json:"activationSteps"` // vendor/app/step of completed activation steps
+	DirectoryEntry  bool       `json:"directoryEntry"`  // OIDC workspace user entry removed
+	Attributes      []string   `json:"attributes"`      // Custom member attribute keys cleared
+}
+
+// Empty reports whether the user had nothing to offboard
+func (r OffboardingReport) Empty() bool {
+	return !r.MembershipRemoved && len(r.Roles) == 0 && len(r.Teams) == 0 && len(r.BPOs) == 0 && len(r.Statuses) == 0 &&
+		len(r.AuthData) == 0 && len(r.ActivationSteps) == 0 && !r.DirectoryEntry && len(r.Attributes) == 0
+}
@@ -0,0 +1,99 @@
+package rubix
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openbyte-os/sdk-go/app"
+)
+
+// PermissionDecisions holds the outcome of evaluating a set of permissions for a single lookup
+type PermissionDecisions struct {
+	Lookup    Lookup
+	Decisions map[string]bool // permission key -> allowed
+}
+
+// Allowed reports whether every given permission was allowed, no permissions are always allowed
+func (d PermissionDecisions) Allowed(permissions ...app.ScopedKey) bool {
+	for _, perm := range permissions {
+		if !d.Decisions[perm.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+// PermissionGrant is a role contributing to a user's effective permission
+type PermissionGrant struct {
+	Role          string      `json:"role"`                    // Role assigned to the user
+	InheritedFrom string      `json:"inheritedFrom,omitempty"` // Ancestor role defining the permission
+	Allow         bool        `json:"allow"`
+	Conditions    []Condition `json:"conditions,omitempty"`
+}
+
+// PermissionMatrixEntry is the effective permission of a single user
+type PermissionMatrixEntry struct {
+	UserID      string               `json:"userID"`
+	Name        string               `json:"name"`
+	Email       string               `json:"email"`
+	Permission  string               `json:"permission"`
+	Effect      app.PermissionEffect `json:"effect"`
+	Conditional bool                 `json:"conditional"` // Allowed only when role conditions are met
+	Grants      []PermissionGrant    `json:"grants"`
+}
+
+// PermissionMatrix lists who can do what within a workspace
+type PermissionMatrix struct {
+	Workspace   string                  `json:"workspace"`
+	GeneratedAt time.Time               `json:"generatedAt"`
+	Entries     []PermissionMatrixEntry `json:"entries"`
+}
+
+func (m *PermissionMatrix) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+func (m *PermissionMatrix) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"user_id", "name", "email", "permission", "effect", "conditional", "roles", "conditions"}); err != nil {
+		return err
+	}
+	for _, entry := range m.Entries {
+		var roles []string
+		var conditions []Condition
+		for _, grant := range entry.Grants {
+			role := grant.Role
+			if grant.InheritedFrom != "" {
+				role += " (via " + grant.InheritedFrom + ")"
+			}
+			roles = append(roles, role)
+			for _, c := range grant.Conditions {
+				if !c.Empty() {
+					conditions = append(conditions, c)
+				}
+			}
+		}
+		conditionsJson := ""
+		if len(conditions) > 0 {
+			b, err := json.Marshal(conditions)
+			if err != nil {
+				return err
+			}
+			conditionsJson = string(b)
+		}
+		if err := cw.Write([]string{
+			entry.UserID, entry.Name, entry.Email, entry.Permission, string(entry.Effect),
+			strconv.FormatBool(entry.Conditional), strings.Join(roles, ";"), conditionsJson,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,68 @@
+package rubix
+
+import "strings"
+
+// Principal keys reserved for membership fields, usable alongside custom attribute keys in conditions
+const (
+	PrincipalKeyPrefix         = "member."
+	PrincipalKeyMembershipType = PrincipalKeyPrefix + "type"
+	PrincipalKeyPartnerID      = PrincipalKeyPrefix + "partnerID"
+)
+
+// Principal describes the member a lookup is for, used by conditions on principal attributes
+type Principal struct {
+	MembershipType MembershipType
+	PartnerID      string
+	Attributes     map[string]any
+	Schema         map[string]MemberAttribute // Types of the custom attributes
+}
+
+// NewPrincipal builds the principal for a membership
+func NewPrincipal(member Membership, schema map[string]MemberAttribute) *Principal {
+	return &Principal{MembershipType: member.Type, PartnerID: member.PartnerID, Attributes: member.Attributes, Schema: schema}
+}
+
+// values merges the membership fields into the custom attributes, unset fields are left out
+func (p *Principal) values() (map[string]any, map[string]MemberAttribute) {
+	values := make(map[string]any, len(p.Attributes)+2)
+	for k, v := range p.Attributes {
+		values[k] = v
+	}
+	if p.MembershipType != "" {
+		values[PrincipalKeyMembershipType] = string(p.MembershipType)
+	}
+	if p.PartnerID != "" {
+		values[PrincipalKeyPartnerID] = p.PartnerID
+	}
+	return values, p.Schema
+}
+
+// Match reports whether the principal satisfies the filter
+func (p *Principal) Match(filter AttributeFilter) bool {
+	values, schema := p.values()
+	return filter.Match(values, schema)
+}
+
+func isPrincipalKey(key string) bool {
+	return strings.HasPrefix(key, PrincipalKeyPrefix)
+}
+
+// RequiresPrincipal reports whether the condition, or any nested expression, filters on principal attributes
+func (c Condition) RequiresPrincipal() bool {
+	if len(c.Principal) > 0 {
+		return true
+	}
+	return c.Expression != nil && c.Expression.requiresPrincipal()
+}
+
+func (e ConditionExpression) requiresPrincipal() bool {
+	if e.Condition != nil && e.Condition.RequiresPrincipal() {
+		return true
+	}
+	for _, operand := range e.Operands {
+		if operand.requiresPrincipal() {
+			return true
+		}
+	}
+	return false
+}
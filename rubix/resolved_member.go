@@ -17,4 +17,7 @@ type MemberFilter struct {
 	Source       string   // "", "native", "oidc"
 	ProviderUUID string   // filter to specific OIDC provider
 	UserIDs      []string // specific user IDs
+	Attributes   []AttributeFilter
+	// IncludeAttributes loads each member's custom attributes, they are always loaded when filtering by them
+	IncludeAttributes bool
 }
@@ -1,5 +1,7 @@
 package rubix
 
+import "time"
+
 type Role struct {
 	Workspace    string
 	ID           string
@@ -11,12 +13,20 @@ type Role struct {
 	Permissions  []RolePermission // Not on roles table
 	Resources    []RoleResource   // Not on roles table
 	Conditions   Condition
+
+	// ParentRoles are the roles this role inherits permissions from
+	ParentRoles []string // Not on roles table
+	// InheritedPermissions are resolved from ParentRoles, RolePermission.Role
+	// identifies the ancestor that defines each permission. Permissions defined
+	// directly on this role take precedence and are not repeated here.
+	InheritedPermissions []RolePermission // Not on roles table
 }
 
 type UserRole struct {
 	Workspace string
 	User      string
 	Role      string
+	ExpiresAt time.Time // Zero for permanent assignments
 }
 
 type RolePermission struct {
@@ -50,9 +60,14 @@ type MutateRolePayload struct {
 	Description     *string
 	BlueprintKey    *string
 	UsersToAdd      []string
+	UserExpiry      map[string]time.Time // user -> assignment expiry
+	ShortenExpiry   bool                 // re-granted users take the given expiry even when it ends access sooner
 	UsersToRem      []string
 	PermsToAdd      []string
 	PermsToRem      []string
+	PermsToDeny     []string
+	ParentsToAdd    []string
+	ParentsToRem    []string
 	Conditions      *Condition
 	PermOptionToAdd map[string]map[string][]string // permission -> meta key -> values
 }
@@ -83,6 +98,28 @@ func WithUsersToAdd(users ...string) MutateRoleOption {
 	}
 }
 
+// WithUsersToAddUntil assigns the role to users until the expiry, after which
+// the assignment is ignored and removed by SweepExpiredRoleGrants
+func WithUsersToAddUntil(expiry time.Time, users ...string) MutateRoleOption {
+	return func(p *MutateRolePayload) {
+		if p.UserExpiry == nil {
+			p.UserExpiry = make(map[string]time.Time)
+		}
+		for _, u := range users {
+			p.UserExpiry[u] = expiry
+		}
+		p.UsersToAdd = append(p.UsersToAdd, users...)
+	}
+}
+
+// WithUserExpiryShortened lets users being added replace a permanent or later
+// expiry, re-granting otherwise only ever extends an existing assignment
+func WithUserExpiryShortened() MutateRoleOption {
+	return func(p *MutateRolePayload) {
+		p.ShortenExpiry = true
+	}
+}
+
 func WithUsersToRemove(users ...string) MutateRoleOption {
 	return func(p *MutateRolePayload) {
 		p.UsersToRem = append(p.UsersToRem, users...)
@@ -101,6 +138,26 @@ func WithPermsToRemove(perms ...string) MutateRoleOption {
 	}
 }
 
+// WithPermsToDeny adds explicit deny entries, overriding any grant of the
+// same permission from another role or an inherited parent role.
+func WithPermsToDeny(perms ...string) MutateRoleOption {
+	return func(p *MutateRolePayload) {
+		p.PermsToDeny = append(p.PermsToDeny, perms...)
+	}
+}
+
+func WithParentRolesToAdd(roles ...string) MutateRoleOption {
+	return func(p *MutateRolePayload) {
+		p.ParentsToAdd = append(p.ParentsToAdd, roles...)
+	}
+}
+
+func WithParentRolesToRemove(roles ...string) MutateRoleOption {
+	return func(p *MutateRolePayload) {
+		p.ParentsToRem = append(p.ParentsToRem, roles...)
+	}
+}
+
 func WithBlueprintKey(key string) MutateRoleOption {
 	return func(p *MutateRolePayload) {
 		p.BlueprintKey = &key
@@ -0,0 +1,59 @@
+package rubix
+
+// PermissionAccess summarises a user's effective permission without evaluating session conditions
+type PermissionAccess string
+
+const (
+	PermissionAccessNone        PermissionAccess = "none"
+	PermissionAccessAllow       PermissionAccess = "allow"
+	PermissionAccessConditional PermissionAccess = "conditional" // allowed only when role conditions are met
+	PermissionAccessDeny        PermissionAccess = "deny"
+)
+
+// Grants reports whether the access allows the permission, at least under some conditions
+func (a PermissionAccess) Grants() bool {
+	return a == PermissionAccessAllow || a == PermissionAccessConditional
+}
+
+type PermissionChange struct {
+	Permission string           `json:"permission"`
+	Before     PermissionAccess `json:"before"`
+	After      PermissionAccess `json:"after"`
+}
+
+func (c PermissionChange) Gained() bool { return !c.Before.Grants() && c.After.Grants() }
+func (c PermissionChange) Lost() bool   { return c.Before.Grants() && !c.After.Grants() }
+
+// UserPermissionDelta lists the permissions whose effective access changes for a user
+type UserPermissionDelta struct {
+	UserID  string             `json:"userID"`
+	Name    string             `json:"name"`
+	Email   string             `json:"email"`
+	Changes []PermissionChange `json:"changes"`
+}
+
+func (d UserPermissionDelta) Gained() []string {
+	var perms []string
+	for _, c := range d.Changes {
+		if c.Gained() {
+			perms = append(perms, c.Permission)
+		}
+	}
+	return perms
+}
+
+func (d UserPermissionDelta) Lost() []string {
+	var perms []string
+	for _, c := range d.Changes {
+		if c.Lost() {
+			perms = append(perms, c.Permission)
+		}
+	}
+	return perms
+}
+
+// PolicySimulation is the impact of a proposed change, only users whose access changes are listed
+type PolicySimulation struct {
+	Workspace string                `json:"workspace"`
+	Users     []UserPermissionDelta `json:"users"`
+}
@@ -1,18 +1,100 @@
 package rubix
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
 type User struct {
 	UserID string `json:"userID"`
 	Name   string `json:"name"`
 	Email  string `json:"email"`
+	UserProfile
+}
+
+// UserProfile holds the global preferences of a user, shared by every workspace
+type UserProfile struct {
+	Locale      string            `json:"locale,omitempty"`   // BCP 47 tag, e.g. en-GB
+	TimeZone    string            `json:"timeZone,omitempty"` // IANA name, e.g. Europe/London
+	AvatarURL   string            `json:"avatarURL,omitempty"`
+	Phone       string            `json:"phone,omitempty"` // E.164, e.g. +447700900123
+	Preferences map[string]string `json:"preferences,omitempty"`
 }
 
+// Location returns the user's time zone, UTC when unset or unknown
+func (p UserProfile) Location() *time.Location {
+	if p.TimeZone != "" {
+		if loc, err := time.LoadLocation(p.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// EndOfDay returns the end of the user's local day containing t
+func (p UserProfile) EndOfDay(t time.Time) time.Time {
+	local := t.In(p.Location())
+	y, m, d := local.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, local.Location())
+}
+
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
 type MutateUserOption func(*MutateUserPayload)
 
 type MutateUserPayload struct {
 	Name          *string
 	Email         *string
 	RolesToAdd    []string
+	RoleExpiry    map[string]time.Time // role -> assignment expiry
 	RolesToRemove []string
+	ShortenExpiry bool // re-granted roles take the given expiry even when it ends access sooner
+
+	Locale      *string
+	TimeZone    *string
+	AvatarURL   *string
+	Phone       *string
+	Preferences map[string]string // Merged into existing preferences, an empty value removes the key
+}
+
+// HasProfile reports whether the payload changes the users table
+func (p MutateUserPayload) HasProfile() bool {
+	return p.Name != nil || p.Email != nil || p.Locale != nil || p.TimeZone != nil ||
+		p.AvatarURL != nil || p.Phone != nil || len(p.Preferences) > 0
+}
+
+// ValidateProfile checks the profile fields being set, empty values clear a field and are always valid
+func (p MutateUserPayload) ValidateProfile() error {
+	if p.Locale != nil && *p.Locale != "" {
+		if _, err := language.Parse(*p.Locale); err != nil {
+			return fmt.Errorf("invalid locale %q", *p.Locale)
+		}
+	}
+	if p.TimeZone != nil && *p.TimeZone != "" {
+		if _, err := time.LoadLocation(*p.TimeZone); err != nil {
+			return fmt.Errorf("unknown time zone %q", *p.TimeZone)
+		}
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" {
+		u, err := url.Parse(*p.AvatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid avatar URL %q", *p.AvatarURL)
+		}
+	}
+	if p.Phone != nil && *p.Phone != "" && !phonePattern.MatchString(*p.Phone) {
+		return fmt.Errorf("invalid phone number %q, expected E.164 format", *p.Phone)
+	}
+	for key := range p.Preferences {
+		if key == "" {
+			return errors.New("preference key is required")
+		}
+	}
+	return nil
 }
 
 func WithUserName(name string) MutateUserOption {
@@ -23,12 +105,66 @@ func WithUserEmail(email string) MutateUserOption {
 	return func(p *MutateUserPayload) { p.Email = &email }
 }
 
+// WithUserLocale sets the BCP 47 locale, normalised to its canonical form
+func WithUserLocale(locale string) MutateUserOption {
+	return func(p *MutateUserPayload) {
+		if tag, err := language.Parse(locale); err == nil {
+			locale = tag.String()
+		}
+		p.Locale = &locale
+	}
+}
+
+func WithUserTimeZone(timeZone string) MutateUserOption {
+	return func(p *MutateUserPayload) { p.TimeZone = &timeZone }
+}
+
+func WithUserAvatarURL(avatarURL string) MutateUserOption {
+	return func(p *MutateUserPayload) { p.AvatarURL = &avatarURL }
+}
+
+func WithUserPhone(phone string) MutateUserOption {
+	return func(p *MutateUserPayload) { p.Phone = &phone }
+}
+
+// WithUserPreference sets a display preference, an empty value removes it
+func WithUserPreference(key, value string) MutateUserOption {
+	return func(p *MutateUserPayload) {
+		if p.Preferences == nil {
+			p.Preferences = make(map[string]string)
+		}
+		p.Preferences[key] = value
+	}
+}
+
 func WithRolesToAdd(roles ...string) MutateUserOption {
 	return func(p *MutateUserPayload) {
 		p.RolesToAdd = append(p.RolesToAdd, roles...)
 	}
 }
 
+// WithRolesToAddUntil assigns roles until the expiry, after which the
+// assignment is ignored and removed by SweepExpiredRoleGrants
+func WithRolesToAddUntil(expiry time.Time, roles ...string) MutateUserOption {
+	return func(p *MutateUserPayload) {
+		if p.RoleExpiry == nil {
+			p.RoleExpiry = make(map[string]time.Time)
+		}
+		for _, r := range roles {
+			p.RoleExpiry[r] = expiry
+		}
+		p.RolesToAdd = append(p.RolesToAdd, roles...)
+	}
+}
+
+// WithRoleExpiryShortened lets roles being added replace a permanent or later
+// expiry, re-granting otherwise only ever extends an existing assignment
+func WithRoleExpiryShortened() MutateUserOption {
+	return func(p *MutateUserPayload) {
+		p.ShortenExpiry = true
+	}
+}
+
 func WithRolesToRemove(roles ...string) MutateUserOption {
 	return func(p *MutateUserPayload) {
 		p.RolesToRemove = append(p.RolesToRemove, roles...)
@@ -0,0 +1,53 @@
+package rubix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserProfileEndOfDay(t *testing.T) {
+	at := time.Date(2024, 3, 1, 22, 30, 0, 0, time.UTC)
+
+	if got := (UserProfile{}).EndOfDay(at); !got.Equal(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("UTC end of day = %v", got)
+	}
+	// 22:30 UTC is already the next morning in Tokyo
+	tokyo := UserProfile{TimeZone: "Asia/Tokyo"}
+	if got := tokyo.EndOfDay(at); !got.Equal(time.Date(2024, 3, 2, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("Tokyo end of day = %v", got.UTC())
+	}
+	if got := (UserProfile{TimeZone: "Nowhere/Special"}).Location(); got != time.UTC {
+		t.Errorf("expected unknown zone to fall back to UTC, got %v", got)
+	}
+}
+
+func TestMutateUserPayloadValidateProfile(t *testing.T) {
+	cases := []struct {
+		option  MutateUserOption
+		wantErr bool
+	}{
+		{WithUserLocale("en-gb"), false},
+		{WithUserLocale("not a locale"), true},
+		{WithUserTimeZone("Europe/London"), false},
+		{WithUserTimeZone("Mars/Olympus"), true},
+		{WithUserAvatarURL("https://cdn.example.com/a.png"), false},
+		{WithUserAvatarURL("javascript:alert(1)"), true},
+		{WithUserPhone("+447700900123"), false},
+		{WithUserPhone("07700 900123"), true},
+		{WithUserPhone(""), false},
+		{WithUserPreference("", "x"), true},
+	}
+	for i, tc := range cases {
+		payload := MutateUserPayload{}
+		tc.option(&payload)
+		if err := payload.ValidateProfile(); (err != nil) != tc.wantErr {
+			t.Errorf("case %d: err = %v, wantErr %v", i, err, tc.wantErr)
+		}
+	}
+
+	payload := MutateUserPayload{}
+	WithUserLocale("en-gb")(&payload)
+	if *payload.Locale != "en-GB" {
+		t.Errorf("expected canonical locale, got %q", *payload.Locale)
+	}
+}
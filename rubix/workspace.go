@@ -58,6 +58,9 @@ type Workspace struct {
 	EmailDomainWhitelist  []string          `json:"emailDomainWhitelist"`
 	EmailDomainApproval   map[string]string `json:"emailDomainApproval"`
 	MemberApprovalMode    string            `json:"memberApprovalMode"`
+	// AccessRequestApprover is the permission required to decide role access requests,
+	// when empty only owners may decide
+	AccessRequestApprover string `json:"accessRequestApprover"`
 }
 
 func WorkspaceFromJson(jsonBytes []byte) (*Workspace, error) {
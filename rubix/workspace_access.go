@@ -0,0 +1,27 @@
+package rubix
+
+const (
+	MemberApprovalModeAuto  = "auto"  // eligible users join immediately
+	MemberApprovalModeQueue = "queue" // eligible users join as pending until approved
+)
+
+type WorkspaceAccessResult string
+
+const (
+	WorkspaceAccessAllow           WorkspaceAccessResult = "allow"
+	WorkspaceAccessDeny            WorkspaceAccessResult = "deny"
+	WorkspaceAccessPendingApproval WorkspaceAccessResult = "pending_approval"
+)
+
+// WorkspaceAccessDecision is the combined result of the workspace gatekeeping rules.
+// Membership is nil when the user is not yet a member, in which case an allow means they may join.
+type WorkspaceAccessDecision struct {
+	Result     WorkspaceAccessResult `json:"result"`
+	Reasons    []string              `json:"reasons,omitempty"`
+	Conditions []ConditionFailure    `json:"conditions,omitempty"`
+	Membership *Membership           `json:"membership,omitempty"`
+}
+
+func (d WorkspaceAccessDecision) Allowed() bool {
+	return d.Result == WorkspaceAccessAllow
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+const ipGroupSourceExternal = "external"
+
+// DefaultIPGroupSyncInterval is used by Run when given no positive interval
+const DefaultIPGroupSyncInterval = time.Hour
+
+// IPGroupSyncer refreshes externally sourced IP groups from their URL.
+// With a JSONPath the response is decoded as JSON, otherwise it is read as one entry per line.
+type IPGroupSyncer struct {
+	Provider     Provider
+	Client       *http.Client
+	MaxBodyBytes int64
+}
+
+func NewIPGroupSyncer(provider Provider) *IPGroupSyncer {
+	return &IPGroupSyncer{
+		Provider:     provider,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		MaxBodyBytes: 10 << 20,
+	}
+}
+
+// Run syncs every external group immediately and then on each interval, until the context is done
+func (s *IPGroupSyncer) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultIPGroupSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = s.SyncAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs every external group, a failure is recorded against its group and does not stop the others
+func (s *IPGroupSyncer) SyncAll(ctx context.Context) error {
+	groups, err := s.Provider.GetExternalIPGroups()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.sync(ctx, group); err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", group.Workspace, group.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SyncGroup fetches the group entries, replacing them only when the whole list is valid
+func (s *IPGroupSyncer) SyncGroup(ctx context.Context, workspace, groupID string) error {
+	group, err := s.Provider.GetIPGroup(workspace, groupID)
+	if err != nil {
+		return err
+	}
+	return s.sync(ctx, *group)
+}
+
+func (s *IPGroupSyncer) sync(ctx context.Context, group rubix.IPGroup) error {
+	if group.Source != ipGroupSourceExternal {
+		return errors.New("IP group is not externally sourced")
+	}
+
+	entries, err := s.fetch(ctx, group.ExternalURL, group.JSONPath)
+	if err != nil {
+		if recordErr := s.Provider.MutateIPGroup(group.Workspace, group.ID, rubix.WithIPGroupSyncError(err.Error())); recordErr != nil {
+			return errors.Join(err, recordErr)
+		}
+		return err
+	}
+
+	return s.Provider.MutateIPGroup(group.Workspace, group.ID,
+		rubix.WithIPGroupEntries(entries),
+		rubix.WithIPGroupLastSynced(time.Now().UTC().Format(time.RFC3339)),
+		rubix.WithIPGroupSyncError(""),
+	)
+}
+
+func (s *IPGroupSyncer) fetch(ctx context.Context, url, jsonPath string) ([]string, error) {
+	if url == "" {
+		return nil, errors.New("no external URL configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body := io.Reader(resp.Body)
+	if s.MaxBodyBytes > 0 {
+		body = io.LimitReader(resp.Body, s.MaxBodyBytes+1)
+	}
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxBodyBytes > 0 && int64(len(raw)) > s.MaxBodyBytes {
+		return nil, fmt.Errorf("response exceeds %d bytes", s.MaxBodyBytes)
+	}
+
+	var entries []string
+	if jsonPath == "" {
+		entries = parseEntryLines(raw)
+	} else if entries, err = extractJSONPath(raw, jsonPath); err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("no entries found")
+	}
+	for _, entry := range entries {
+		if !rubix.ValidIPEntry(entry) {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseEntryLines reads one entry per line, ignoring blank lines and # comments
+func parseEntryLines(raw []byte) []string {
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// extractJSONPath evaluates a JSONPath subset, e.g. $.prefixes[*].ip_prefix, collecting the matched strings.
+// Supported are child names (.name or ['name']), array indexes ([0]) and wildcards (.* or [*]).
+func extractJSONPath(raw []byte, path string) ([]string, error) {
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []any{doc}
+	for _, step := range steps {
+		var next []any
+		for _, node := range nodes {
+			next = append(next, step.apply(node)...)
+		}
+		nodes = next
+	}
+
+	var entries []string
+	var collect func(node any) error
+	collect = func(node any) error {
+		switch v := node.(type) {
+		case string:
+			entries = append(entries, strings.TrimSpace(v))
+		case []any:
+			for _, item := range v {
+				if err := collect(item); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("JSONPath %s matched a non-string value", path)
+		}
+		return nil
+	}
+	for _, node := range nodes {
+		if err := collect(node); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+type jsonPathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func (s jsonPathStep) apply(node any) []any {
+	switch v := node.(type) {
+	case map[string]any:
+		if s.wildcard {
+			ret := make([]any, 0, len(v))
+			for _, child := range v {
+				ret = append(ret, child)
+			}
+			return ret
+		}
+		if child, ok := v[s.name]; ok && !s.isIndex {
+			return []any{child}
+		}
+	case []any:
+		if s.wildcard {
+			return v
+		}
+		if s.isIndex && s.index >= 0 && s.index < len(v) {
+			return []any{v[s.index]}
+		}
+	}
+	return nil
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	invalid := fmt.Errorf("invalid JSONPath %q", path)
+	rest, ok := strings.CutPrefix(strings.TrimSpace(path), "$")
+	if !ok {
+		return nil, invalid
+	}
+
+	var steps []jsonPathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, invalid
+			}
+			steps = append(steps, jsonPathStep{name: name, wildcard: name == "*"})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, invalid
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case selector == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				steps = append(steps, jsonPathStep{name: selector[1 : len(selector)-1]})
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil {
+					return nil, invalid
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			return nil, invalid
+		}
+	}
+	return steps, nil
+}
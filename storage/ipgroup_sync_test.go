@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubex/rubix-storage/rubix"
+	"github.com/kubex/rubix-storage/storage/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSyncTestProvider(t *testing.T) *sql.Provider {
+	t.Helper()
+	p := &sql.Provider{SqlLite: true, PrimaryDSN: "file:" + filepath.Join(t.TempDir(), "sync.db")}
+	if err := p.Initialize(); err != nil {
+		t.Fatalf("init provider: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestIPGroupSyncer(t *testing.T) {
+	responses := map[string]string{
+		"/ranges.json": `{"prefixes":[{"ip_prefix":"10.0.0.0/8"},{"ip_prefix":"192.168.1.1"}],"ipv6":["2001:db8::/32"]}`,
+		"/ips.txt":     "# office ranges\n1.1.1.1\n\n2.2.2.0/24 # vpn\n",
+		"/invalid":     `{"prefixes":[{"ip_prefix":"10.0.0.0/8"},{"ip_prefix":"nope"}]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	p := newSyncTestProvider(t)
+	ws := "ws-sync"
+	groups := []rubix.IPGroup{
+		{ID: "aws", Name: "AWS", Source: "external", ExternalURL: srv.URL + "/ranges.json", JSONPath: "$.prefixes[*].ip_prefix"},
+		{ID: "text", Name: "Text", Source: "external", ExternalURL: srv.URL + "/ips.txt"},
+		{ID: "broken", Name: "Broken", Source: "external", ExternalURL: srv.URL + "/invalid", JSONPath: "$.prefixes[*].ip_prefix", Entries: []string{"8.8.8.8"}, EntryCount: 1},
+		{ID: "manual", Name: "Manual", Source: "manual", Entries: []string{"9.9.9.9"}, EntryCount: 1},
+	}
+	for _, g := range groups {
+		if err := p.CreateIPGroup(ws, g); err != nil {
+			t.Fatalf("CreateIPGroup %s: %v", g.ID, err)
+		}
+	}
+
+	syncer := NewIPGroupSyncer(p)
+	err := syncer.SyncAll(context.Background())
+	assert.ErrorContains(t, err, "ws-sync/broken")
+
+	aws, _ := p.GetIPGroup(ws, "aws")
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1"}, aws.Entries)
+	assert.Equal(t, 2, aws.EntryCount)
+	assert.NotEmpty(t, aws.LastSynced)
+	assert.Empty(t, aws.SyncError)
+
+	text, _ := p.GetIPGroup(ws, "text")
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.0/24"}, text.Entries)
+
+	broken, _ := p.GetIPGroup(ws, "broken")
+	assert.Equal(t, []string{"8.8.8.8"}, broken.Entries, "entries kept when the sync fails")
+	assert.Contains(t, broken.SyncError, `invalid entry "nope"`)
+	assert.Empty(t, broken.LastSynced)
+
+	// Fixing the source clears the recorded error
+	responses["/invalid"] = `{"prefixes":[{"ip_prefix":"172.16.0.0/12"}]}`
+	assert.NoError(t, syncer.SyncGroup(context.Background(), ws, "broken"))
+	broken, _ = p.GetIPGroup(ws, "broken")
+	assert.Equal(t, []string{"172.16.0.0/12"}, broken.Entries)
+	assert.Empty(t, broken.SyncError)
+
+	// Failures are recorded against the group
+	assert.NoError(t, p.MutateIPGroup(ws, "text", rubix.WithIPGroupExternalURL(srv.URL+"/missing")))
+	assert.Error(t, syncer.SyncGroup(context.Background(), ws, "text"))
+	text, _ = p.GetIPGroup(ws, "text")
+	assert.Contains(t, text.SyncError, "404")
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.0/24"}, text.Entries)
+
+	assert.Error(t, syncer.SyncGroup(context.Background(), ws, "manual"))
+
+	// Run falls back to the default interval rather than panicking
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotPanics(t, func() { syncer.Run(ctx, 0) })
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	doc := []byte(`{"result":{"ipv4_cidrs":["1.0.0.0/24"],"ipv6_cidrs":["2400:cb00::/32"]},"list":[["a"],["b","c"]],"n":1}`)
+
+	testCases := []struct {
+		path     string
+		expected []string
+		err      bool
+	}{
+		{"$.result.ipv4_cidrs", []string{"1.0.0.0/24"}, false},
+		{"$['result']['ipv6_cidrs'][0]", []string{"2400:cb00::/32"}, false},
+		{"$.list[1]", []string{"b", "c"}, false},
+		{"$.list[*][0]", []string{"a", "b"}, false},
+		{"$.missing", nil, false},
+		{"$.n", nil, true},
+		{"result", nil, true},
+		{"$.list[x]", nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			entries, err := extractJSONPath(doc, tc.path)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, entries)
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"time"
+
 	"github.com/kubex/rubix-storage/rubix"
 	"github.com/openbyte-os/sdk-go/app"
 )
@@ -16,6 +18,7 @@ type Provider interface {
 
 	GetAuthData(workspaceUuid, userUuid string, appIDs ...app.GlobalAppID) ([]rubix.DataResult, error)
 	SetWorkspaceAccessCondition(workspaceUuid string, condition rubix.Condition) error
+	CheckWorkspaceAccess(lookup rubix.Lookup, email, providerUUID string) (*rubix.WorkspaceAccessDecision, error)
 	GetOIDCProviders(workspace string) ([]rubix.OIDCProvider, error)
 	GetOIDCProvider(workspace, uuid string) (*rubix.OIDCProvider, error)
 	CreateOIDCProvider(workspace string, provider rubix.OIDCProvider) error
@@ -24,6 +27,7 @@ type Provider interface {
 	SetWorkspaceEmailDomainWhitelist(workspaceUuid string, domains []string) error
 	SetWorkspaceEmailDomainApproval(workspaceUuid string, approval map[string]string) error
 	SetWorkspaceMemberApprovalMode(workspaceUuid string, mode string) error
+	SetWorkspaceAccessRequestApprover(workspaceUuid string, permission string) error
 	SetWorkspaceName(workspaceUuid, name string) error
 	SetWorkspaceIcon(workspaceUuid, icon string) error
 	SetWorkspaceDefaultApp(workspaceUuid string, defaultApp string) error
@@ -48,15 +52,26 @@ type Provider interface {
 	DeleteWorkspaceUser(workspace, userID string) error
 	GetResolvedMembers(workspace string, filter rubix.MemberFilter) ([]rubix.ResolvedMember, error)
 
+	// Custom member attributes
+	SetMemberAttributeDefinition(workspace string, attribute rubix.MemberAttribute) error
+	GetMemberAttributeDefinitions(workspace string) ([]rubix.MemberAttribute, error)
+	DeleteMemberAttributeDefinition(workspace, key string) error
+	SetMemberAttributes(workspace, user string, values map[string]any) error
+	GetWorkspaceMembersWithAttributes(workspace string, userIDs ...string) ([]rubix.Membership, error)
+
 	SetAuthData(workspaceUuid, userUuid string, value rubix.DataResult, forceUpdate bool) error
 
 	GetSettings(workspace, vendor, app string, keys ...string) ([]rubix.Setting, error)
 	SetSetting(workspace, vendor, app, key, value string) error
 
+	// AddUserToWorkspace adds a pending membership, self requests from eligible domains in auto approval mode are approved
 	AddUserToWorkspace(workspaceID, userID string, as rubix.MembershipType, partnerId string, source ...rubix.MembershipSource) error
 
 	GetPermissionStatements(lookup rubix.Lookup, permissions ...app.ScopedKey) ([]app.PermissionStatement, error)
 	UserHasPermission(lookup rubix.Lookup, permissions ...app.ScopedKey) (bool, error)
+	GetPermissionDecisions(lookups []rubix.Lookup, permissions ...app.ScopedKey) ([]rubix.PermissionDecisions, error)
+	GetUsersPermissionDecisions(lookup rubix.Lookup, userIDs []string, permissions ...app.ScopedKey) (map[string]rubix.PermissionDecisions, error)
+	GetWorkspacePermissionMatrix(workspace string, permissions ...app.ScopedKey) (*rubix.PermissionMatrix, error)
 
 	CreateUser(userID, name, email string) error
 	GetUser(workspace, userID string) (*rubix.User, error)
@@ -66,22 +81,59 @@ type Provider interface {
 	ClearUserStatusLogout(workspaceUuid, userUuid string) error
 	MutateUser(workspace, user string, options ...rubix.MutateUserOption) error
 
-	SetMembershipType(workspace, user string, accountType rubix.MembershipType) error
-	SetMembershipState(workspace, user string, accountType rubix.MembershipState) error
-	RemoveUserFromWorkspace(workspace, user string) error
+	SetMembershipType(workspace, user string, accountType rubix.MembershipType, options ...rubix.MembershipChangeOption) error
+	SetMembershipState(workspace, user string, accountType rubix.MembershipState, options ...rubix.MembershipChangeOption) error
+	GetMembershipHistory(workspace, user string) (rubix.MembershipTimeline, error)
+
+	RemoveUserFromWorkspace(workspace, user string, options ...rubix.MembershipChangeOption) error
+	OffboardUser(workspace, user string, options ...rubix.MembershipChangeOption) (*rubix.OffboardingReport, error)
+	GetOffboardingReports(workspace, user string) ([]rubix.OffboardingReport, error)
+
+	// Membership expiry
+	SetMembershipExpiry(workspace, user string, expiresAt time.Time, action rubix.MembershipExpiryAction, options ...rubix.MembershipChangeOption) error
+	ExtendMembership(workspace, user string, by time.Duration, options ...rubix.MembershipChangeOption) (time.Time, error)
+	GetExpiringMembers(workspace string, within time.Duration) ([]rubix.Membership, error)
+	SweepExpiredMemberships() ([]rubix.Membership, error)
+	ImportMembers(workspace string, rows []rubix.MemberImportRow, batchSize int) (*rubix.MemberImportReport, error)
+
+	// Member approval queue
+	ListPendingMembers(workspace string) ([]rubix.PendingMember, error)
+	ApproveMember(workspace, user, decidedBy string) error
+	RejectMember(workspace, user, decidedBy, reason string) error
+	GetMemberApprovalDefaults(workspace string) ([]rubix.MemberApprovalDefaults, error)
+	SetMemberApprovalDefaults(workspace, domain string, roles, teams []string) error
+
+	// Invitations
+	CreateInvitation(workspace string, invitation rubix.Invitation, ttl time.Duration) (string, error)
+	GetInvitation(workspace, id string) (*rubix.Invitation, error)
+	GetInvitations(workspace string, states ...rubix.InvitationState) ([]rubix.Invitation, error)
+	ResendInvitation(workspace, id string, ttl time.Duration) (string, error)
+	RevokeInvitation(workspace, id string) error
+	AcceptInvitation(token, user string) (*rubix.Invitation, error)
 
 	GetRole(workspace, role string) (*rubix.Role, error)
 	GetRoles(workspace string) ([]rubix.Role, error)
 	GetUserRoles(workspace, user string) ([]rubix.UserRole, error)
+	SweepExpiredRoleGrants() ([]rubix.UserRole, error)
 	DeleteRole(workspace, role string) error
 	CreateRole(workspace, role, name, description string, permissions, users []string, conditions rubix.Condition, scimManaged bool) error
 	MutateRole(workspace, role string, options ...rubix.MutateRoleOption) error
 	GetRolePermissions(workspace, role string) ([]rubix.RolePermission, error)
 
+	// Role Access Requests
+	CreateAccessRequest(workspace string, request rubix.AccessRequest) error
+	GetAccessRequest(workspace, id string) (*rubix.AccessRequest, error)
+	GetAccessRequests(workspace string, states ...rubix.AccessRequestState) ([]rubix.AccessRequest, error)
+	ApproveAccessRequest(approver rubix.Lookup, id, reason string) error
+	DenyAccessRequest(approver rubix.Lookup, id, reason string) error
+
 	// Role Resources
 	GetRoleResources(workspace, role string) ([]rubix.RoleResource, error)
 	AddRoleResources(workspace, role string, resources ...rubix.RoleResource) error
 	RemoveRoleResources(workspace, role string, resources ...rubix.RoleResource) error
+	SimulateRoleChange(workspace, role string, options ...rubix.MutateRoleOption) (*rubix.PolicySimulation, error)
+	SimulateUserChange(workspace, user string, options ...rubix.MutateUserOption) (*rubix.PolicySimulation, error)
+	GetAccessibleResources(lookup rubix.Lookup, permission app.ScopedKey, resourceType rubix.ResourceType) ([]string, error)
 
 	// Teams
 	GetTeam(workspace, team string) (*rubix.Team, error)
@@ -137,6 +189,14 @@ type Provider interface {
 	CreateIPGroup(workspace string, group rubix.IPGroup) error
 	MutateIPGroup(workspace, groupID string, options ...rubix.MutateIPGroupOption) error
 	DeleteIPGroup(workspace, groupID string) error
+	GetExternalIPGroups() ([]rubix.IPGroup, error)
+
+	// Location Groups
+	GetLocationGroup(workspace, groupID string) (*rubix.LocationGroup, error)
+	GetLocationGroups(workspace string) ([]rubix.LocationGroup, error)
+	CreateLocationGroup(workspace string, group rubix.LocationGroup) error
+	MutateLocationGroup(workspace, groupID string, options ...rubix.MutateLocationGroupOption) error
+	DeleteLocationGroup(workspace, groupID string) error
 
 	// App Activation
 	CompleteActivationStep(workspace, user, vendor, app, stepID string) error
@@ -0,0 +1,217 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+	"github.com/openbyte-os/sdk-go/app"
+)
+
+const accessRequestColumns = "workspace, id, requester, role, justification, duration, state, created_at, decided_by, decided_at, decision_reason, grant_expires_at"
+
+func (p *Provider) CreateAccessRequest(workspace string, request rubix.AccessRequest) error {
+	if request.ID == "" || request.Requester == "" || request.Role == "" {
+		return errors.New("access request requires an id, requester and role")
+	}
+	state, _, found, err := p.currentMembership(workspace, request.Requester)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("requester %q: %w", request.Requester, rubix.ErrNoResultFound)
+	}
+	if state != rubix.MembershipStateActive {
+		return fmt.Errorf("requester membership is %s: %w", strings.ToLower(state.Display()), rubix.ErrPermissionDenied)
+	}
+	if _, err := p.GetRole(workspace, request.Role); err != nil {
+		return err
+	}
+	var permanent int
+	if err := p.primaryConnection.QueryRow("SELECT COUNT(*) FROM user_roles WHERE workspace = ? AND user = ? AND role = ? AND expires_at IS NULL",
+		workspace, request.Requester, request.Role).Scan(&permanent); err != nil {
+		return err
+	}
+	if permanent > 0 {
+		return fmt.Errorf("role %q is already held: %w", request.Role, rubix.ErrDuplicate)
+	}
+
+	var pending int
+	if err := p.primaryConnection.QueryRow("SELECT COUNT(*) FROM role_access_requests WHERE workspace = ? AND requester = ? AND role = ? AND state = ?",
+		workspace, request.Requester, request.Role, rubix.AccessRequestStatePending).Scan(&pending); err != nil {
+		return err
+	}
+	if pending > 0 {
+		return rubix.ErrDuplicate
+	}
+
+	_, err = p.primaryConnection.Exec(
+		"INSERT INTO role_access_requests (workspace, id, requester, role, justification, duration, state, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		workspace, request.ID, request.Requester, request.Role, request.Justification, int64(request.RequestedDuration.Seconds()), rubix.AccessRequestStatePending, time.Now().UTC(),
+	)
+	if p.isDuplicateConflict(err) {
+		return rubix.ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+func (p *Provider) GetAccessRequest(workspace, id string) (*rubix.AccessRequest, error) {
+	rows, err := p.primaryConnection.Query("SELECT "+accessRequestColumns+" FROM role_access_requests WHERE workspace = ? AND id = ?", workspace, id)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := scanAccessRequests(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, rubix.ErrNoResultFound
+	}
+	return &requests[0], nil
+}
+
+// GetAccessRequests returns the workspace access requests, newest first, optionally limited to the given states
+func (p *Provider) GetAccessRequests(workspace string, states ...rubix.AccessRequestState) ([]rubix.AccessRequest, error) {
+	query := "SELECT " + accessRequestColumns + " FROM role_access_requests WHERE workspace = ?"
+	args := []any{workspace}
+	if len(states) > 0 {
+		query += " AND state IN (?" + strings.Repeat(",?", len(states)-1) + ")"
+		for _, state := range states {
+			args = append(args, state)
+		}
+	}
+	rows, err := p.primaryConnection.Query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAccessRequests(rows)
+}
+
+func scanAccessRequests(rows *sql.Rows) ([]rubix.AccessRequest, error) {
+	defer rows.Close()
+	var requests []rubix.AccessRequest
+	for rows.Next() {
+		var r rubix.AccessRequest
+		var duration int64
+		createdAt := sql.NullString{}
+		decidedAt := sql.NullString{}
+		grantExpiresAt := sql.NullString{}
+		if err := rows.Scan(&r.Workspace, &r.ID, &r.Requester, &r.Role, &r.Justification, &duration, &r.State, &createdAt, &r.DecidedBy, &decidedAt, &r.DecisionReason, &grantExpiresAt); err != nil {
+			return nil, err
+		}
+		r.RequestedDuration = time.Duration(duration) * time.Second
+		r.CreatedAt = timeFromString(createdAt.String)
+		if decidedAt.Valid {
+			r.DecidedAt = timeFromString(decidedAt.String)
+		}
+		if grantExpiresAt.Valid {
+			r.GrantExpiresAt = timeFromString(grantExpiresAt.String)
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// ApproveAccessRequest grants the requested role, time-bound when a duration was requested,
+// and records the decision. The approver must hold the workspace approver permission.
+func (p *Provider) ApproveAccessRequest(approver rubix.Lookup, id, reason string) error {
+	request, err := p.decideAccessRequest(approver, id, rubix.AccessRequestStateApproved, reason)
+	if err != nil {
+		return err
+	}
+
+	option := rubix.WithRolesToAdd(request.Role)
+	if !request.GrantExpiresAt.IsZero() {
+		option = rubix.WithRolesToAddUntil(request.GrantExpiresAt, request.Role)
+	}
+	if err = p.MutateUser(approver.WorkspaceUUID, request.Requester, option); err != nil {
+		// Return the request to the queue so it can be decided again
+		_, _ = p.primaryConnection.Exec("UPDATE role_access_requests SET state = ?, decided_by = '', decided_at = NULL, decision_reason = '', grant_expires_at = NULL WHERE workspace = ? AND id = ?",
+			rubix.AccessRequestStatePending, approver.WorkspaceUUID, id)
+		return err
+	}
+
+	p.update()
+	return nil
+}
+
+func (p *Provider) DenyAccessRequest(approver rubix.Lookup, id, reason string) error {
+	if _, err := p.decideAccessRequest(approver, id, rubix.AccessRequestStateDenied, reason); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+// decideAccessRequest checks the approver and moves a pending request to the decided state.
+// The state change is conditional, so concurrent decisions cannot both succeed.
+func (p *Provider) decideAccessRequest(approver rubix.Lookup, id string, state rubix.AccessRequestState, reason string) (*rubix.AccessRequest, error) {
+	request, err := p.GetAccessRequest(approver.WorkspaceUUID, id)
+	if err != nil {
+		return nil, err
+	}
+	if request.State != rubix.AccessRequestStatePending {
+		return nil, rubix.ErrAlreadyDecided
+	}
+	if request.Requester == approver.UserUUID {
+		return nil, rubix.ErrPermissionDenied
+	}
+	if allowed, err := p.canDecideAccessRequests(approver); err != nil {
+		return nil, err
+	} else if !allowed {
+		return nil, rubix.ErrPermissionDenied
+	}
+
+	now := time.Now().UTC()
+	grantExpiresAt := sql.NullTime{}
+	if state == rubix.AccessRequestStateApproved && request.RequestedDuration > 0 {
+		grantExpiresAt = sql.NullTime{Time: now.Add(request.RequestedDuration), Valid: true}
+	}
+
+	res, err := p.primaryConnection.Exec("UPDATE role_access_requests SET state = ?, decided_by = ?, decided_at = ?, decision_reason = ?, grant_expires_at = ? WHERE workspace = ? AND id = ? AND state = ?",
+		state, approver.UserUUID, now, reason, grantExpiresAt, approver.WorkspaceUUID, id, rubix.AccessRequestStatePending)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, rubix.ErrAlreadyDecided
+	}
+
+	request.State = state
+	request.DecidedBy = approver.UserUUID
+	request.DecidedAt = now
+	request.DecisionReason = reason
+	request.GrantExpiresAt = grantExpiresAt.Time
+	return request, nil
+}
+
+// canDecideAccessRequests checks the workspace approver permission, falling back to owners
+func (p *Provider) canDecideAccessRequests(approver rubix.Lookup) (bool, error) {
+	workspace, err := p.RetrieveWorkspace(approver.WorkspaceUUID)
+	if err != nil {
+		return false, err
+	}
+	if workspace == nil {
+		return false, rubix.ErrNoResultFound
+	}
+
+	if workspace.AccessRequestApprover != "" {
+		return p.UserHasPermission(approver, app.ScopedKeyFromString(workspace.AccessRequestApprover))
+	}
+
+	member, err := p.getWorkspaceMember(approver.WorkspaceUUID, approver.UserUUID)
+	if approver.UserUUID == "" || errors.Is(err, rubix.ErrNoResultFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return member.Type == rubix.MembershipTypeOwner && member.State == rubix.MembershipStateActive, nil
+}
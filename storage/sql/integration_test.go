@@ -1,8 +1,15 @@
 package sql
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -575,3 +582,1896 @@ func TestWorkspaceApplications(t *testing.T) {
 		t.Fatalf("expected single app vx/ax/prod after overwrite, got %+v", got)
 	}
 }
+
+func TestRoleInheritance(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-inherit"
+	if err := p.CreateWorkspace(ws, "Inherit", "inherit", "inherit.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+
+	permRead := app.NewScopedKey("read", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	permWrite := app.NewScopedKey("write", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	permDelete := app.NewScopedKey("delete", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+
+	if err := p.CreateRole(ws, "agent", "Agent", "", []string{permRead.String(), permDelete.String()}, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole agent: %v", err)
+	}
+	if err := p.CreateRole(ws, "senior", "Senior Agent", "", []string{permWrite.String()}, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole senior: %v", err)
+	}
+	if err := p.CreateRole(ws, "supervisor", "Supervisor", "", nil, []string{"u1"}, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole supervisor: %v", err)
+	}
+	if err := p.MutateRole(ws, "senior", rubix.WithParentRolesToAdd("agent"), rubix.WithPermsToDeny(permDelete.String())); err != nil {
+		t.Fatalf("MutateRole senior: %v", err)
+	}
+	if err := p.MutateRole(ws, "supervisor", rubix.WithParentRolesToAdd("senior")); err != nil {
+		t.Fatalf("MutateRole supervisor: %v", err)
+	}
+
+	// Cycles are rejected
+	if err := p.MutateRole(ws, "agent", rubix.WithParentRolesToAdd("supervisor")); !errors.Is(err, rubix.ErrRoleInheritanceCycle) {
+		t.Fatalf("expected ErrRoleInheritanceCycle, got %v", err)
+	}
+	// A rejected change leaves the role untouched, including parents it would have removed
+	if err := p.MutateRole(ws, "senior", rubix.WithParentRolesToRemove("agent"), rubix.WithParentRolesToAdd("supervisor")); !errors.Is(err, rubix.ErrRoleInheritanceCycle) {
+		t.Fatalf("expected ErrRoleInheritanceCycle, got %v", err)
+	}
+	if senior, err := p.GetRole(ws, "senior"); err != nil || !reflect.DeepEqual(senior.ParentRoles, []string{"agent"}) {
+		t.Fatalf("expected senior to keep its parent, got %+v err=%v", senior, err)
+	}
+
+	lookup := rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1"}
+	if ok, err := p.UserHasPermission(lookup, permRead, permWrite); err != nil || !ok {
+		t.Fatalf("expected inherited read+write, ok=%v err=%v", ok, err)
+	}
+	// Senior denies delete, overriding the grant from agent
+	if ok, err := p.UserHasPermission(lookup, permDelete); err != nil || ok {
+		t.Fatalf("expected delete denied by child role, ok=%v err=%v", ok, err)
+	}
+
+	// Parents must exist
+	if err := p.MutateRole(ws, "supervisor", rubix.WithParentRolesToAdd("ghost")); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected missing parent to be rejected, got %v", err)
+	}
+
+	// Conditions on a role in the middle of the chain apply to what it inherits
+	if err := p.MutateRole(ws, "senior", rubix.WithConditions(rubix.Condition{RequireMFA: true})); err != nil {
+		t.Fatalf("MutateRole senior conditions: %v", err)
+	}
+	if ok, err := p.UserHasPermission(lookup, permRead); err != nil || ok {
+		t.Fatalf("expected read through senior to require MFA, ok=%v err=%v", ok, err)
+	}
+	mfaLookup := lookup
+	mfaLookup.MFA = true
+	if ok, err := p.UserHasPermission(mfaLookup, permRead, permWrite); err != nil || !ok {
+		t.Fatalf("expected read+write with MFA, ok=%v err=%v", ok, err)
+	}
+	if err := p.MutateRole(ws, "senior", rubix.WithConditions(rubix.Condition{})); err != nil {
+		t.Fatalf("MutateRole senior clear conditions: %v", err)
+	}
+
+	role, err := p.GetRole(ws, "supervisor")
+	if err != nil {
+		t.Fatalf("GetRole: %v", err)
+	}
+	if len(role.ParentRoles) != 1 || role.ParentRoles[0] != "senior" {
+		t.Fatalf("expected parent senior, got %v", role.ParentRoles)
+	}
+	if len(role.Permissions) != 0 || len(role.InheritedPermissions) != 3 {
+		t.Fatalf("expected 0 direct and 3 inherited permissions, got %+v / %+v", role.Permissions, role.InheritedPermissions)
+	}
+	for _, rp := range role.InheritedPermissions {
+		if rp.Permission == permDelete.String() && (rp.Allow || rp.Role != "senior") {
+			t.Fatalf("expected delete deny inherited from senior, got %+v", rp)
+		}
+	}
+
+	// Resources come from the assigned role, including for inherited permissions
+	if err := p.AddRoleResources(ws, "supervisor",
+		rubix.RoleResource{Resource: "brand-b", ResourceType: rubix.ResourceTypeBrand},
+		rubix.RoleResource{Resource: "brand-a", ResourceType: rubix.ResourceTypeBrand},
+		rubix.RoleResource{Resource: "email", ResourceType: rubix.ResourceTypeChannel},
+	); err != nil {
+		t.Fatalf("AddRoleResources: %v", err)
+	}
+	brands, err := p.GetAccessibleResources(lookup, permRead, rubix.ResourceTypeBrand)
+	if err != nil || len(brands) != 2 || brands[0] != "brand-a" {
+		t.Fatalf("expected [brand-a brand-b], got %v err=%v", brands, err)
+	}
+	if denied, err := p.GetAccessibleResources(lookup, permDelete, rubix.ResourceTypeBrand); err != nil || len(denied) != 0 {
+		t.Fatalf("expected no resources for denied permission, got %v err=%v", denied, err)
+	}
+
+	// Removing the parent drops inherited permissions
+	if err := p.MutateRole(ws, "supervisor", rubix.WithParentRolesToRemove("senior")); err != nil {
+		t.Fatalf("MutateRole remove parent: %v", err)
+	}
+	if ok, err := p.UserHasPermission(lookup, permRead); err != nil || ok {
+		t.Fatalf("expected read removed with parent, ok=%v err=%v", ok, err)
+	}
+}
+
+func TestPermissionDecisions(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-batch"
+	if err := p.CreateWorkspace(ws, "Batch", "batch", "batch.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+
+	permRead := app.NewScopedKey("read", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	permWrite := app.NewScopedKey("write", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+
+	if err := p.CreateRole(ws, "reader", "Reader", "", []string{permRead.String()}, []string{"u1", "u2"}, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole reader: %v", err)
+	}
+	if err := p.CreateRole(ws, "writer", "Writer", "", []string{permWrite.String()}, []string{"u2"}, rubix.Condition{RequireMFA: true}, false); err != nil {
+		t.Fatalf("CreateRole writer: %v", err)
+	}
+
+	decisions, err := p.GetUsersPermissionDecisions(rubix.Lookup{WorkspaceUUID: ws, MFA: true}, []string{"u1", "u2", "u3"}, permRead, permWrite)
+	if err != nil {
+		t.Fatalf("GetUsersPermissionDecisions: %v", err)
+	}
+	if len(decisions) != 3 {
+		t.Fatalf("expected 3 users, got %d", len(decisions))
+	}
+	if !decisions["u1"].Allowed(permRead) || decisions["u1"].Allowed(permWrite) {
+		t.Fatalf("u1 decisions mismatch: %+v", decisions["u1"].Decisions)
+	}
+	if !decisions["u2"].Allowed(permRead, permWrite) {
+		t.Fatalf("u2 decisions mismatch: %+v", decisions["u2"].Decisions)
+	}
+	if decisions["u3"].Allowed(permRead) {
+		t.Fatalf("u3 decisions mismatch: %+v", decisions["u3"].Decisions)
+	}
+
+	// Conditions are evaluated per lookup
+	list, err := p.GetPermissionDecisions([]rubix.Lookup{
+		{WorkspaceUUID: ws, UserUUID: "u2", MFA: false},
+		{WorkspaceUUID: ws, UserUUID: "u2", MFA: true},
+	}, permWrite)
+	if err != nil {
+		t.Fatalf("GetPermissionDecisions: %v", err)
+	}
+	if list[0].Allowed(permWrite) || !list[1].Allowed(permWrite) {
+		t.Fatalf("expected write to depend on MFA, got %+v %+v", list[0].Decisions, list[1].Decisions)
+	}
+}
+
+func TestWorkspacePermissionMatrix(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-matrix"
+	if err := p.CreateWorkspace(ws, "Matrix", "matrix", "matrix.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for _, u := range []string{"u1", "u2"} {
+		if err := p.CreateUser(u, u, u+"@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := p.AddUserToWorkspace(ws, u, rubix.MembershipTypeMember, ""); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+	}
+
+	if err := p.CreateRole(ws, "agent", "Agent", "", []string{"perm.read"}, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole agent: %v", err)
+	}
+	if err := p.CreateRole(ws, "senior", "Senior", "", []string{"perm.write"}, []string{"u2"}, rubix.Condition{RequireMFA: true}, false); err != nil {
+		t.Fatalf("CreateRole senior: %v", err)
+	}
+	if err := p.MutateRole(ws, "agent", rubix.WithUsersToAdd("u1")); err != nil {
+		t.Fatalf("MutateRole agent: %v", err)
+	}
+	if err := p.MutateRole(ws, "senior", rubix.WithParentRolesToAdd("agent")); err != nil {
+		t.Fatalf("MutateRole senior: %v", err)
+	}
+
+	matrix, err := p.GetWorkspacePermissionMatrix(ws)
+	if err != nil {
+		t.Fatalf("GetWorkspacePermissionMatrix: %v", err)
+	}
+	if len(matrix.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", matrix.Entries)
+	}
+	u2Read := matrix.Entries[1]
+	if u2Read.UserID != "u2" || u2Read.Permission != "perm.read" || !u2Read.Conditional || u2Read.Grants[0].InheritedFrom != "agent" {
+		t.Fatalf("unexpected u2 read entry: %+v", u2Read)
+	}
+	if matrix.Entries[0].Conditional || matrix.Entries[0].Effect != app.PermissionEffectAllow {
+		t.Fatalf("expected unconditional allow for u1, got %+v", matrix.Entries[0])
+	}
+
+	buf := &bytes.Buffer{}
+	if err := matrix.WriteCSV(buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("expected header and 3 csv rows, got %d err=%v", len(records), err)
+	}
+	if records[2][6] != "senior (via agent)" {
+		t.Fatalf("unexpected csv roles column: %q", records[2][6])
+	}
+}
+
+func TestTimeBoundRoleGrants(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-expiry"
+	if err := p.CreateWorkspace(ws, "Expiry", "expiry", "expiry.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	perm := app.NewScopedKey("escalate", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	if err := p.CreateRole(ws, "incident", "Incident", "", []string{perm.String()}, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+
+	if err := p.MutateUser(ws, "u1", rubix.WithRolesToAddUntil(time.Now().Add(-time.Minute), "incident")); err != nil {
+		t.Fatalf("MutateUser expired grant: %v", err)
+	}
+	if err := p.MutateRole(ws, "incident", rubix.WithUsersToAddUntil(time.Now().Add(time.Hour), "u2")); err != nil {
+		t.Fatalf("MutateRole future grant: %v", err)
+	}
+
+	if ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1"}, perm); err != nil || ok {
+		t.Fatalf("expected expired grant ignored, ok=%v err=%v", ok, err)
+	}
+	if ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u2"}, perm); err != nil || !ok {
+		t.Fatalf("expected unexpired grant honoured, ok=%v err=%v", ok, err)
+	}
+	roles, err := p.GetUserRoles(ws, "u2")
+	if err != nil || len(roles) != 1 || roles[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected u2 grant with expiry, got %+v err=%v", roles, err)
+	}
+
+	updates := 0
+	_ = p.AfterUpdate(func() { updates++ })
+	removed, err := p.SweepExpiredRoleGrants()
+	if err != nil {
+		t.Fatalf("SweepExpiredRoleGrants: %v", err)
+	}
+	if len(removed) != 1 || removed[0].User != "u1" || removed[0].Role != "incident" {
+		t.Fatalf("expected u1 grant removed, got %+v", removed)
+	}
+	if updates != 1 {
+		t.Fatalf("expected one update notification, got %d", updates)
+	}
+
+	// Re-granting without expiry makes the assignment permanent
+	if err := p.MutateUser(ws, "u2", rubix.WithRolesToAdd("incident")); err != nil {
+		t.Fatalf("MutateUser permanent: %v", err)
+	}
+	roles, err = p.GetUserRoles(ws, "u2")
+	if err != nil || len(roles) != 1 || !roles[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected permanent grant, got %+v err=%v", roles, err)
+	}
+
+	// Re-granting with an expiry never shortens a permanent or later grant
+	if err := p.MutateUser(ws, "u2", rubix.WithRolesToAddUntil(time.Now().Add(time.Hour), "incident")); err != nil {
+		t.Fatalf("MutateUser time-bound over permanent: %v", err)
+	}
+	if roles, _ = p.GetUserRoles(ws, "u2"); len(roles) != 1 || !roles[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected permanent grant kept, got %+v", roles)
+	}
+	later := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	if err := p.MutateRole(ws, "incident", rubix.WithUsersToAddUntil(later, "u3")); err != nil {
+		t.Fatalf("MutateRole: %v", err)
+	}
+	if err := p.MutateRole(ws, "incident", rubix.WithUsersToAddUntil(time.Now().Add(time.Hour), "u3")); err != nil {
+		t.Fatalf("MutateRole earlier expiry: %v", err)
+	}
+	if roles, _ = p.GetUserRoles(ws, "u3"); len(roles) != 1 || !roles[0].ExpiresAt.Equal(later) {
+		t.Fatalf("expected later expiry kept, got %+v", roles)
+	}
+
+	// Shortening must be asked for explicitly
+	sooner := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := p.MutateUser(ws, "u2", rubix.WithRolesToAddUntil(sooner, "incident"), rubix.WithRoleExpiryShortened()); err != nil {
+		t.Fatalf("MutateUser shortened: %v", err)
+	}
+	if roles, _ = p.GetUserRoles(ws, "u2"); len(roles) != 1 || !roles[0].ExpiresAt.Equal(sooner) {
+		t.Fatalf("expected shortened grant, got %+v", roles)
+	}
+}
+
+func TestAccessRequests(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-requests"
+	if err := p.CreateWorkspace(ws, "Requests", "requests", "requests.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for user, typ := range map[string]rubix.MembershipType{"owner": rubix.MembershipTypeOwner, "agent": rubix.MembershipTypeMember, "lead": rubix.MembershipTypeMember} {
+		if err := p.AddUserToWorkspace(ws, user, typ, ""); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+		if err := p.SetMembershipState(ws, user, rubix.MembershipStateActive); err != nil {
+			t.Fatalf("SetMembershipState: %v", err)
+		}
+	}
+	perm := app.NewScopedKey("refund", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	if err := p.CreateRole(ws, "refunds", "Refunds", "", []string{perm.String()}, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+
+	req := rubix.AccessRequest{ID: "req-1", Requester: "agent", Role: "refunds", Justification: "Covering shift", RequestedDuration: time.Hour}
+	if err := p.CreateAccessRequest(ws, req); err != nil {
+		t.Fatalf("CreateAccessRequest: %v", err)
+	}
+	req.ID = "req-2"
+	if err := p.CreateAccessRequest(ws, req); err != rubix.ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate for second pending request, got %v", err)
+	}
+	if err := p.CreateAccessRequest(ws, rubix.AccessRequest{ID: "req-3", Requester: "agent", Role: "missing"}); err != rubix.ErrNoResultFound {
+		t.Fatalf("expected ErrNoResultFound for unknown role, got %v", err)
+	}
+
+	// Only active members can request access
+	if err := p.CreateAccessRequest(ws, rubix.AccessRequest{ID: "req-4", Requester: "stranger", Role: "refunds"}); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound for a non-member, got %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "newcomer", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.CreateAccessRequest(ws, rubix.AccessRequest{ID: "req-5", Requester: "newcomer", Role: "refunds"}); !errors.Is(err, rubix.ErrPermissionDenied) {
+		t.Fatalf("expected ErrPermissionDenied for a pending member, got %v", err)
+	}
+
+	// Members without the approver permission cannot decide
+	if err := p.ApproveAccessRequest(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "lead"}, "req-1", ""); err != rubix.ErrPermissionDenied {
+		t.Fatalf("expected ErrPermissionDenied, got %v", err)
+	}
+	if err := p.ApproveAccessRequest(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "owner"}, "req-1", "ok"); err != nil {
+		t.Fatalf("ApproveAccessRequest: %v", err)
+	}
+	if ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "agent"}, perm); err != nil || !ok {
+		t.Fatalf("expected role granted on approval, ok=%v err=%v", ok, err)
+	}
+	roles, err := p.GetUserRoles(ws, "agent")
+	if err != nil || len(roles) != 1 || roles[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected time-bound grant, got %+v err=%v", roles, err)
+	}
+	decided, err := p.GetAccessRequest(ws, "req-1")
+	if err != nil || decided.State != rubix.AccessRequestStateApproved || decided.DecidedBy != "owner" || decided.GrantExpiresAt.IsZero() {
+		t.Fatalf("unexpected decided request: %+v err=%v", decided, err)
+	}
+	if err := p.DenyAccessRequest(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "owner"}, "req-1", ""); err != rubix.ErrAlreadyDecided {
+		t.Fatalf("expected ErrAlreadyDecided, got %v", err)
+	}
+
+	// A configured approver permission replaces the owner fallback
+	approve := app.NewScopedKey("approve", &app.GlobalAppID{VendorID: "v", AppID: "access"}).String()
+	if err := p.CreateRole(ws, "approvers", "Approvers", "", []string{approve}, []string{"lead"}, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole approvers: %v", err)
+	}
+	if err := p.SetWorkspaceAccessRequestApprover(ws, approve); err != nil {
+		t.Fatalf("SetWorkspaceAccessRequestApprover: %v", err)
+	}
+	if err := p.CreateAccessRequest(ws, rubix.AccessRequest{ID: "req-4", Requester: "owner", Role: "refunds"}); err != nil {
+		t.Fatalf("CreateAccessRequest req-4: %v", err)
+	}
+	if err := p.DenyAccessRequest(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "lead"}, "req-4", "not needed"); err != nil {
+		t.Fatalf("DenyAccessRequest: %v", err)
+	}
+	pending, err := p.GetAccessRequests(ws, rubix.AccessRequestStatePending)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending requests, got %+v err=%v", pending, err)
+	}
+
+	// Roles already held permanently cannot be requested
+	if err := p.MutateUser(ws, "agent", rubix.WithRolesToAdd("refunds")); err != nil {
+		t.Fatalf("MutateUser: %v", err)
+	}
+	if err := p.CreateAccessRequest(ws, rubix.AccessRequest{ID: "req-6", Requester: "agent", Role: "refunds", RequestedDuration: time.Hour}); !errors.Is(err, rubix.ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate for a permanently held role, got %v", err)
+	}
+
+	// Approving a time-bound request never shortens a permanent grant made in the meantime
+	if err := p.CreateAccessRequest(ws, rubix.AccessRequest{ID: "req-7", Requester: "owner", Role: "refunds", RequestedDuration: time.Hour}); err != nil {
+		t.Fatalf("CreateAccessRequest req-7: %v", err)
+	}
+	if err := p.MutateUser(ws, "owner", rubix.WithRolesToAdd("refunds")); err != nil {
+		t.Fatalf("MutateUser: %v", err)
+	}
+	if err := p.ApproveAccessRequest(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "lead"}, "req-7", ""); err != nil {
+		t.Fatalf("ApproveAccessRequest req-7: %v", err)
+	}
+	if roles, err := p.GetUserRoles(ws, "owner"); err != nil || len(roles) != 1 || !roles[0].ExpiresAt.IsZero() {
+		t.Fatalf("expected permanent grant kept, got %+v err=%v", roles, err)
+	}
+	if removed, err := p.SweepExpiredRoleGrants(); err != nil || len(removed) != 0 {
+		t.Fatalf("expected nothing to sweep, got %+v err=%v", removed, err)
+	}
+}
+
+func TestLocationGroups(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-loc"
+	if err := p.CreateWorkspace(ws, "Loc", "loc", "loc.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+
+	if err := p.CreateLocationGroup(ws, rubix.LocationGroup{ID: "offices", Name: "Offices", Locations: []string{"gb", " us-ca "}}); err != nil {
+		t.Fatalf("CreateLocationGroup: %v", err)
+	}
+	if err := p.CreateLocationGroup(ws, rubix.LocationGroup{ID: "offices", Name: "Dup"}); err == nil {
+		t.Fatalf("expected duplicate location group error")
+	}
+	group, err := p.GetLocationGroup(ws, "offices")
+	if err != nil {
+		t.Fatalf("GetLocationGroup: %v", err)
+	}
+	if len(group.Locations) != 2 || group.Locations[0] != "GB" || group.Locations[1] != "US-CA" {
+		t.Fatalf("expected normalised locations, got %v", group.Locations)
+	}
+
+	perm := app.NewScopedKey("read", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	if err := p.CreateRole(ws, "office", "Office", "", []string{perm.String()}, []string{"u1"}, rubix.Condition{AllowedLocations: []string{"offices"}}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+
+	allowed := func(location string) bool {
+		ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1", GeoLocation: location}, perm)
+		if err != nil {
+			t.Fatalf("UserHasPermission: %v", err)
+		}
+		return ok
+	}
+	if !allowed("GB-LND") || !allowed("US-CA") || allowed("US-NY") {
+		t.Fatalf("unexpected decisions for workspace location group")
+	}
+
+	if err := p.MutateLocationGroup(ws, "offices", rubix.WithLocationGroupLocations([]string{"EU"})); err != nil {
+		t.Fatalf("MutateLocationGroup: %v", err)
+	}
+	if !allowed("FR") || allowed("GB") {
+		t.Fatalf("expected updated group to reference the built-in EU group")
+	}
+	decisions, err := p.GetPermissionDecisions([]rubix.Lookup{{WorkspaceUUID: ws, UserUUID: "u1", GeoLocation: "de"}}, perm)
+	if err != nil || !decisions[0].Allowed(perm) {
+		t.Fatalf("expected batch decisions to resolve location groups, got %v %v", decisions, err)
+	}
+
+	if err := p.MutateLocationGroup(ws, "offices", rubix.WithLocationGroupLocations([]string{"GB", "USA-CALIFORNIA"})); err == nil {
+		t.Fatalf("expected malformed location to be rejected")
+	}
+	if err := p.CreateLocationGroup(ws, rubix.LocationGroup{ID: "regions", Locations: []string{"offices", "EEA", "gb-lnd"}}); err != nil {
+		t.Fatalf("CreateLocationGroup referencing groups: %v", err)
+	}
+	if err := p.CreateLocationGroup(ws, rubix.LocationGroup{ID: "bad", Locations: []string{"not-a-group"}}); err == nil {
+		t.Fatalf("expected unknown location group to be rejected")
+	}
+	if err := p.MutateLocationGroup(ws, "offices", rubix.WithLocationGroupName("Head offices")); err != nil {
+		t.Fatalf("MutateLocationGroup name: %v", err)
+	}
+	if group, _ := p.GetLocationGroup(ws, "offices"); group == nil || group.Name != "Head offices" {
+		t.Fatalf("expected renamed group, got %+v", group)
+	}
+	if err := p.DeleteLocationGroup(ws, "regions"); err != nil {
+		t.Fatalf("DeleteLocationGroup: %v", err)
+	}
+
+	if err := p.MutateLocationGroup(ws, "missing", rubix.WithLocationGroupName("x")); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound, got %v", err)
+	}
+	if err := p.DeleteLocationGroup(ws, "offices"); err != nil {
+		t.Fatalf("DeleteLocationGroup: %v", err)
+	}
+	if groups, _ := p.GetLocationGroups(ws); len(groups) != 0 {
+		t.Fatalf("expected no location groups, got %v", groups)
+	}
+	if allowed("FR") {
+		t.Fatalf("expected deleted group to match nothing")
+	}
+}
+
+func TestIPGroupMatcherCache(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-ipcache"
+	if err := p.CreateWorkspace(ws, "IP", "ip", "ip.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	perm := app.NewScopedKey("read", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	if err := p.CreateRole(ws, "office", "Office", "", []string{perm.String()}, []string{"u1"}, rubix.Condition{AllowedIPGroups: []string{"office"}}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if err := p.CreateIPGroup(ws, rubix.IPGroup{ID: "office", Name: "Office", Entries: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatalf("CreateIPGroup: %v", err)
+	}
+
+	allowed := func(ip string) bool {
+		ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1", IpAddress: net.ParseIP(ip)}, perm)
+		if err != nil {
+			t.Fatalf("UserHasPermission: %v", err)
+		}
+		return ok
+	}
+	if !allowed("10.1.1.1") || allowed("192.168.1.1") {
+		t.Fatalf("unexpected decisions for initial group")
+	}
+
+	// Changes made outside the provider are not seen until the cache is invalidated
+	if _, err := p.primaryConnection.Exec("UPDATE ip_groups SET entries = ? WHERE workspace = ? AND ip_group = ?", `["172.16.0.0/12"]`, ws, "office"); err != nil {
+		t.Fatalf("update entries: %v", err)
+	}
+	if !allowed("10.1.1.1") {
+		t.Fatalf("expected cached matcher to be used")
+	}
+
+	if err := p.MutateIPGroup(ws, "office", rubix.WithIPGroupEntries([]string{"192.168.0.0/16"})); err != nil {
+		t.Fatalf("MutateIPGroup: %v", err)
+	}
+	if allowed("10.1.1.1") || !allowed("192.168.1.1") {
+		t.Fatalf("expected mutate to invalidate the cached matcher")
+	}
+
+	if err := p.DeleteIPGroup(ws, "office"); err != nil {
+		t.Fatalf("DeleteIPGroup: %v", err)
+	}
+	if !allowed("8.8.8.8") {
+		t.Fatalf("expected deleted group to no longer restrict access")
+	}
+}
+
+func TestIPGroupEntryNormalisation(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-ipnorm"
+	err := p.CreateIPGroup(ws, rubix.IPGroup{ID: "bad", Name: "Bad", Entries: []string{"1.1.1.1", "not-an-ip"}})
+	if !errors.Is(err, rubix.ErrInvalidIPEntry) {
+		t.Fatalf("expected ErrInvalidIPEntry, got %v", err)
+	}
+	if _, err := p.GetIPGroup(ws, "bad"); err != rubix.ErrNoResultFound {
+		t.Fatalf("expected invalid group not to be created, got %v", err)
+	}
+
+	if err := p.CreateIPGroup(ws, rubix.IPGroup{ID: "office", Name: "Office", Entries: []string{"10.0.0.0/25", "10.0.0.5/25", "::ffff:1.1.1.1", "1.1.1.1"}, EntryCount: 99}); err != nil {
+		t.Fatalf("CreateIPGroup: %v", err)
+	}
+	group, _ := p.GetIPGroup(ws, "office")
+	if strings.Join(group.Entries, ",") != "10.0.0.0/25,1.1.1.1" || group.EntryCount != 2 {
+		t.Fatalf("expected normalised entries, got %v (%d)", group.Entries, group.EntryCount)
+	}
+
+	if err := p.MutateIPGroup(ws, "office", rubix.WithIPGroupEntries([]string{"10.0.0.300"})); !errors.Is(err, rubix.ErrInvalidIPEntry) {
+		t.Fatalf("expected ErrInvalidIPEntry, got %v", err)
+	}
+
+	if err := p.MutateIPGroup(ws, "office", rubix.WithIPGroupEntries([]string{"10.0.0.0/25", "10.0.0.128/25", "10.0.0.1", "2.2.2.2"})); err != nil {
+		t.Fatalf("MutateIPGroup entries: %v", err)
+	}
+	group, _ = p.GetIPGroup(ws, "office")
+	if group.EntryCount != 4 {
+		t.Fatalf("expected 4 entries before collapsing, got %v", group.Entries)
+	}
+
+	if err := p.MutateIPGroup(ws, "office", rubix.WithIPGroupCollapseOverlaps(true)); err != nil {
+		t.Fatalf("MutateIPGroup collapse: %v", err)
+	}
+	group, _ = p.GetIPGroup(ws, "office")
+	if !group.CollapseOverlaps || strings.Join(group.Entries, ",") != "2.2.2.2,10.0.0.0/24" || group.EntryCount != 2 {
+		t.Fatalf("expected collapsed entries, got %v (%d)", group.Entries, group.EntryCount)
+	}
+
+	if err := p.MutateIPGroup(ws, "missing", rubix.WithIPGroupEntries([]string{"1.1.1.1"})); err != rubix.ErrNoResultFound {
+		t.Fatalf("expected ErrNoResultFound, got %v", err)
+	}
+}
+
+func TestNestedIPGroups(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-nested"
+	for _, g := range []rubix.IPGroup{
+		{ID: "office", Name: "Office", Entries: []string{"1.1.1.1"}},
+		{ID: "vpn", Name: "VPN", Entries: []string{"10.0.0.0/8"}},
+		{ID: "corporate", Name: "Corporate", Entries: []string{"group:office", "group:vpn"}},
+	} {
+		if err := p.CreateIPGroup(ws, g); err != nil {
+			t.Fatalf("CreateIPGroup %s: %v", g.ID, err)
+		}
+	}
+	perm := app.NewScopedKey("read", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	if err := p.CreateRole(ws, "corp", "Corp", "", []string{perm.String()}, []string{"u1"}, rubix.Condition{AllowedIPGroups: []string{"corporate"}}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	allowed := func(ip string) bool {
+		ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1", IpAddress: net.ParseIP(ip)}, perm)
+		if err != nil {
+			t.Fatalf("UserHasPermission: %v", err)
+		}
+		return ok
+	}
+	if !allowed("1.1.1.1") || !allowed("10.2.2.2") || allowed("8.8.8.8") {
+		t.Fatalf("unexpected decisions for nested group")
+	}
+
+	// Changing a referenced group is reflected through the parent
+	if err := p.MutateIPGroup(ws, "office", rubix.WithIPGroupEntries([]string{"8.8.8.8"})); err != nil {
+		t.Fatalf("MutateIPGroup: %v", err)
+	}
+	if allowed("1.1.1.1") || !allowed("8.8.8.8") {
+		t.Fatalf("expected referenced group change to apply")
+	}
+
+	if err := p.MutateIPGroup(ws, "vpn", rubix.WithIPGroupEntries([]string{"group:corporate"})); err != rubix.ErrIPGroupCycle {
+		t.Fatalf("expected ErrIPGroupCycle, got %v", err)
+	}
+	if err := p.CreateIPGroup(ws, rubix.IPGroup{ID: "loop", Name: "Loop", Entries: []string{"group:loop"}}); err != rubix.ErrIPGroupCycle {
+		t.Fatalf("expected ErrIPGroupCycle for self reference, got %v", err)
+	}
+
+	// References must name existing groups
+	if err := p.CreateIPGroup(ws, rubix.IPGroup{ID: "partners", Name: "Partners", Entries: []string{"group:missing"}}); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound for unknown reference, got %v", err)
+	}
+	if err := p.MutateIPGroup(ws, "vpn", rubix.WithIPGroupEntries([]string{"group:missing"})); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound for unknown reference, got %v", err)
+	}
+
+	// Referenced groups cannot be deleted until nothing references them
+	if err := p.DeleteIPGroup(ws, "office"); !errors.Is(err, rubix.ErrIPGroupInUse) {
+		t.Fatalf("expected ErrIPGroupInUse, got %v", err)
+	}
+	if !allowed("8.8.8.8") {
+		t.Fatalf("expected referenced group to remain")
+	}
+	if err := p.MutateIPGroup(ws, "corporate", rubix.WithIPGroupEntries([]string{"group:vpn"})); err != nil {
+		t.Fatalf("MutateIPGroup: %v", err)
+	}
+	if err := p.DeleteIPGroup(ws, "office"); err != nil {
+		t.Fatalf("DeleteIPGroup: %v", err)
+	}
+
+	// Groups that cannot be loaded fail closed rather than skipping the allowed-group check
+	if _, err := p.primaryConnection.Exec("ALTER TABLE ip_groups RENAME TO ip_groups_unavailable"); err != nil {
+		t.Fatalf("rename ip_groups: %v", err)
+	}
+	p.invalidateIPGroups(ws)
+	if ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1", IpAddress: net.ParseIP("8.8.8.8")}, perm); err == nil || ok {
+		t.Fatalf("expected an error when IP groups cannot be loaded, ok=%v err=%v", ok, err)
+	}
+}
+
+func TestCheckWorkspaceAccess(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-access"
+	if err := p.CreateWorkspace(ws, "Access", "access", "access.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.SetWorkspaceEmailDomainWhitelist(ws, []string{"Example.com"}); err != nil {
+		t.Fatalf("SetWorkspaceEmailDomainWhitelist: %v", err)
+	}
+	if err := p.SetWorkspaceEmailDomainApproval(ws, map[string]string{"contractor.com": rubix.MemberApprovalModeQueue}); err != nil {
+		t.Fatalf("SetWorkspaceEmailDomainApproval: %v", err)
+	}
+	if err := p.SetWorkspaceAccessCondition(ws, rubix.Condition{RequireMFA: true}); err != nil {
+		t.Fatalf("SetWorkspaceAccessCondition: %v", err)
+	}
+	if err := p.CreateOIDCProvider(ws, rubix.OIDCProvider{Uuid: "idp", ProviderName: "IdP", AssumeMFA: true, AutoAcceptMembers: true, MaxSessionAge: 3600}); err != nil {
+		t.Fatalf("CreateOIDCProvider: %v", err)
+	}
+
+	check := func(lookup rubix.Lookup, email, provider string, expected rubix.WorkspaceAccessResult) *rubix.WorkspaceAccessDecision {
+		t.Helper()
+		lookup.WorkspaceUUID = ws
+		decision, err := p.CheckWorkspaceAccess(lookup, email, provider)
+		if err != nil {
+			t.Fatalf("CheckWorkspaceAccess: %v", err)
+		}
+		if decision.Result != expected {
+			t.Fatalf("expected %s, got %s %v", expected, decision.Result, decision.Reasons)
+		}
+		return decision
+	}
+
+	// Non-members are judged on their email domain
+	check(rubix.Lookup{UserUUID: "new", MFA: true}, "new@example.com", "", rubix.WorkspaceAccessAllow)
+	check(rubix.Lookup{UserUUID: "new", MFA: true}, "new@contractor.com", "", rubix.WorkspaceAccessPendingApproval)
+	d := check(rubix.Lookup{UserUUID: "new", MFA: true}, "new@other.com", "", rubix.WorkspaceAccessDeny)
+	if len(d.Reasons) != 1 || d.Reasons[0] != `email domain "other.com" is not permitted` {
+		t.Fatalf("unexpected reasons %v", d.Reasons)
+	}
+
+	// The access condition applies to everyone, with failures reported
+	d = check(rubix.Lookup{UserUUID: "new"}, "new@example.com", "", rubix.WorkspaceAccessDeny)
+	if len(d.Conditions) != 1 || d.Conditions[0].Clause != rubix.ConditionClauseMFA {
+		t.Fatalf("expected MFA failure, got %+v", d.Conditions)
+	}
+
+	// The identity provider vouches for MFA, auto-accepts and bounds the session
+	check(rubix.Lookup{UserUUID: "new", SessionIssued: time.Now()}, "new@contractor.com", "idp", rubix.WorkspaceAccessAllow)
+	check(rubix.Lookup{UserUUID: "new", SessionIssued: time.Now().Add(-2 * time.Hour)}, "new@example.com", "idp", rubix.WorkspaceAccessDeny)
+	check(rubix.Lookup{UserUUID: "new", MFA: true}, "new@example.com", "unknown", rubix.WorkspaceAccessDeny)
+
+	// Members are judged on their state
+	if err := p.SetWorkspaceMemberApprovalMode(ws, rubix.MemberApprovalModeQueue); err != nil {
+		t.Fatalf("SetWorkspaceMemberApprovalMode: %v", err)
+	}
+	check(rubix.Lookup{UserUUID: "new", MFA: true}, "new@example.com", "", rubix.WorkspaceAccessPendingApproval)
+	if err := p.AddUserToWorkspace(ws, "m1", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	d = check(rubix.Lookup{UserUUID: "m1", MFA: true}, "", "", rubix.WorkspaceAccessPendingApproval)
+	if d.Membership == nil || d.Membership.UserID != "m1" {
+		t.Fatalf("expected membership in decision, got %+v", d.Membership)
+	}
+	if err := p.SetMembershipState(ws, "m1", rubix.MembershipStateActive); err != nil {
+		t.Fatalf("SetMembershipState: %v", err)
+	}
+	check(rubix.Lookup{UserUUID: "m1", MFA: true}, "", "", rubix.WorkspaceAccessAllow)
+
+	// Principal conditions use the stored membership, a forged principal is ignored
+	ownersOnly := rubix.Condition{Principal: []rubix.AttributeFilter{{Key: rubix.PrincipalKeyMembershipType, Operator: rubix.AttributeEquals, Values: []string{"owner"}}}}
+	if err := p.SetWorkspaceAccessCondition(ws, ownersOnly); err != nil {
+		t.Fatalf("SetWorkspaceAccessCondition: %v", err)
+	}
+	forged := &rubix.Principal{MembershipType: rubix.MembershipTypeOwner}
+	d = check(rubix.Lookup{UserUUID: "m1", Principal: forged}, "", "", rubix.WorkspaceAccessDeny)
+	if len(d.Conditions) != 1 || d.Conditions[0].Clause != rubix.ConditionClausePrincipal {
+		t.Fatalf("expected principal failure, got %+v", d.Conditions)
+	}
+	partnerExpression := rubix.Not(rubix.Leaf(rubix.Condition{Principal: []rubix.AttributeFilter{{Key: rubix.PrincipalKeyPartnerID, Operator: rubix.AttributeExists}}}))
+	notPartner := rubix.Condition{Expression: &partnerExpression}
+	if err := p.SetWorkspaceAccessCondition(ws, notPartner); err != nil {
+		t.Fatalf("SetWorkspaceAccessCondition: %v", err)
+	}
+	check(rubix.Lookup{UserUUID: "m1"}, "", "", rubix.WorkspaceAccessAllow)
+	// Non-members have no principal, so negated principal filters cannot pass
+	check(rubix.Lookup{UserUUID: "new", Principal: &rubix.Principal{}}, "new@example.com", "", rubix.WorkspaceAccessDeny)
+	if err := p.SetWorkspaceAccessCondition(ws, rubix.Condition{RequireMFA: true}); err != nil {
+		t.Fatalf("SetWorkspaceAccessCondition: %v", err)
+	}
+
+	// Without a user no other member's state applies
+	if d = check(rubix.Lookup{MFA: true}, "", "", rubix.WorkspaceAccessDeny); d.Membership != nil {
+		t.Fatalf("expected no membership without a user, got %+v", d.Membership)
+	}
+	if err := p.SetMembershipState(ws, "m1", rubix.MembershipStateSuspended); err != nil {
+		t.Fatalf("SetMembershipState: %v", err)
+	}
+	d = check(rubix.Lookup{UserUUID: "m1", MFA: true}, "", "", rubix.WorkspaceAccessDeny)
+	if d.Reasons[0] != "membership is suspended" {
+		t.Fatalf("unexpected reasons %v", d.Reasons)
+	}
+
+	if _, err := p.CheckWorkspaceAccess(rubix.Lookup{WorkspaceUUID: "missing"}, "", ""); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound, got %v", err)
+	}
+}
+
+func TestPolicySimulation(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-sim"
+	if err := p.CreateWorkspace(ws, "Sim", "sim", "sim.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for _, u := range []string{"u1", "u2", "u3"} {
+		if err := p.AddUserToWorkspace(ws, u, rubix.MembershipTypeMember, ""); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+	}
+
+	key := func(k string) string {
+		return app.NewScopedKey(k, &app.GlobalAppID{VendorID: "v", AppID: "a"}).String()
+	}
+	if err := p.CreateRole(ws, "reader", "Reader", "", []string{key("read")}, []string{"u1", "u2"}, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole reader: %v", err)
+	}
+	if err := p.CreateRole(ws, "admin", "Admin", "", []string{key("admin")}, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole admin: %v", err)
+	}
+
+	sim, err := p.SimulateRoleChange(ws, "reader",
+		rubix.WithPermsToAdd(key("write")),
+		rubix.WithUsersToAdd("u3"),
+		rubix.WithUsersToRemove("u2"),
+		rubix.WithParentRolesToAdd("admin"),
+		rubix.WithConditions(rubix.Condition{RequireMFA: true}),
+	)
+	if err != nil {
+		t.Fatalf("SimulateRoleChange: %v", err)
+	}
+	if len(sim.Users) != 3 {
+		t.Fatalf("expected 3 affected users, got %+v", sim.Users)
+	}
+	byUser := map[string]rubix.UserPermissionDelta{}
+	for _, d := range sim.Users {
+		byUser[d.UserID] = d
+	}
+	if got := strings.Join(byUser["u1"].Gained(), ","); got != key("admin")+","+key("write") {
+		t.Fatalf("u1 gained %q", got)
+	}
+	if c := byUser["u1"].Changes; c[1].Permission != key("read") || c[1].Before != rubix.PermissionAccessAllow || c[1].After != rubix.PermissionAccessConditional {
+		t.Fatalf("expected u1 read to become conditional, got %+v", c)
+	}
+	if got := strings.Join(byUser["u2"].Lost(), ","); got != key("read") {
+		t.Fatalf("u2 lost %q", got)
+	}
+	if len(byUser["u3"].Gained()) != 3 {
+		t.Fatalf("u3 gained %v", byUser["u3"].Gained())
+	}
+
+	// Nothing was persisted
+	role, err := p.GetRole(ws, "reader")
+	if err != nil {
+		t.Fatalf("GetRole: %v", err)
+	}
+	if len(role.Permissions) != 1 || len(role.ParentRoles) != 0 || role.Conditions.RequireMFA || len(role.Users) != 2 {
+		t.Fatalf("simulation changed the role: %+v", role)
+	}
+
+	sim, err = p.SimulateRoleChange(ws, "reader", rubix.WithPermsToDeny(key("read")))
+	if err != nil {
+		t.Fatalf("SimulateRoleChange deny: %v", err)
+	}
+	if len(sim.Users) != 2 || sim.Users[0].Changes[0].After != rubix.PermissionAccessDeny {
+		t.Fatalf("expected deny for both holders, got %+v", sim.Users)
+	}
+
+	sim, err = p.SimulateUserChange(ws, "u3", rubix.WithRolesToAdd("admin"))
+	if err != nil {
+		t.Fatalf("SimulateUserChange: %v", err)
+	}
+	if len(sim.Users) != 1 || strings.Join(sim.Users[0].Gained(), ",") != key("admin") {
+		t.Fatalf("unexpected user simulation %+v", sim.Users)
+	}
+
+	if _, err := p.SimulateRoleChange(ws, "admin", rubix.WithParentRolesToAdd("admin")); err != rubix.ErrRoleInheritanceCycle {
+		t.Fatalf("expected ErrRoleInheritanceCycle, got %v", err)
+	}
+	if _, err := p.SimulateRoleChange(ws, "missing", rubix.WithPermsToAdd(key("read"))); err != rubix.ErrNoResultFound {
+		t.Fatalf("expected ErrNoResultFound, got %v", err)
+	}
+}
+
+func TestMemberApprovalQueue(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-queue"
+	if err := p.CreateWorkspace(ws, "Queue", "queue", "queue.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.SetWorkspaceMemberApprovalMode(ws, rubix.MemberApprovalModeQueue); err != nil {
+		t.Fatalf("SetWorkspaceMemberApprovalMode: %v", err)
+	}
+	if err := p.SetWorkspaceEmailDomainApproval(ws, map[string]string{"partner.com": rubix.MemberApprovalModeAuto}); err != nil {
+		t.Fatalf("SetWorkspaceEmailDomainApproval: %v", err)
+	}
+	if err := p.CreateTeam(ws, "staff", "Staff", "", nil, false); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if err := p.SetMemberApprovalDefaults(ws, "", []string{"viewer"}, []string{"staff"}); err != nil {
+		t.Fatalf("SetMemberApprovalDefaults: %v", err)
+	}
+	if err := p.SetMemberApprovalDefaults(ws, "Partner.com", []string{"partner"}, nil); err != nil {
+		t.Fatalf("SetMemberApprovalDefaults partner: %v", err)
+	}
+
+	users := map[string]string{"u1": "one@example.com", "u2": "two@partner.com", "u3": "three@example.com"}
+	for _, u := range []string{"u1", "u2", "u3"} {
+		if err := p.CreateUser(u, u, users[u]); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := p.AddUserToWorkspace(ws, u, rubix.MembershipTypeMember, "", rubix.MembershipSourceSelfRequest); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+	}
+
+	pending, err := p.ListPendingMembers(ws)
+	if err != nil {
+		t.Fatalf("ListPendingMembers: %v", err)
+	}
+	// The partner domain approves automatically, the rest wait in the queue
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 pending members, got %d", len(pending))
+	}
+	for _, m := range pending {
+		if m.ApprovalMode != rubix.MemberApprovalModeQueue {
+			t.Fatalf("expected queue mode for %s, got %s", m.UserID, m.ApprovalMode)
+		}
+	}
+	if members, _ := p.GetWorkspaceMembers(ws, "u2"); len(members) != 1 || members[0].State != rubix.MembershipStateActive || members[0].DecisionReason != "auto-approved for domain partner.com" {
+		t.Fatalf("expected u2 auto-approved, got %+v", members)
+	}
+
+	if err := p.ApproveMember(ws, "u1", "owner"); err != nil {
+		t.Fatalf("ApproveMember u1: %v", err)
+	}
+	if err := p.ApproveMember(ws, "u2", "owner"); !errors.Is(err, rubix.ErrAlreadyDecided) {
+		t.Fatalf("expected auto-approved u2 to be decided, got %v", err)
+	}
+	if err := p.RejectMember(ws, "u3", "owner", "unknown contractor"); err != nil {
+		t.Fatalf("RejectMember: %v", err)
+	}
+	if err := p.ApproveMember(ws, "u3", "owner"); !errors.Is(err, rubix.ErrAlreadyDecided) {
+		t.Fatalf("expected ErrAlreadyDecided, got %v", err)
+	}
+	if err := p.ApproveMember(ws, "missing", "owner"); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound, got %v", err)
+	}
+
+	members, _ := p.GetWorkspaceMembers(ws, "u1", "u3")
+	for _, m := range members {
+		if m.DecidedBy != "owner" || m.DecidedAt.IsZero() {
+			t.Fatalf("expected decision recorded for %s: %+v", m.UserID, m)
+		}
+		if m.UserID == "u1" && m.State != rubix.MembershipStateActive {
+			t.Fatalf("expected u1 active, got %v", m.State)
+		}
+		if m.UserID == "u3" && (m.State != rubix.MembershipStateRejected || m.DecisionReason != "unknown contractor") {
+			t.Fatalf("expected u3 rejected with reason, got %+v", m)
+		}
+	}
+
+	if roles, _ := p.GetUserRoleIDs(ws, "u1"); len(roles) != 1 || roles[0] != "viewer" {
+		t.Fatalf("expected workspace default role for u1, got %v", roles)
+	}
+	if roles, _ := p.GetUserRoleIDs(ws, "u2"); len(roles) != 1 || roles[0] != "partner" {
+		t.Fatalf("expected domain default role for u2, got %v", roles)
+	}
+	team, err := p.GetTeam(ws, "staff")
+	if err != nil {
+		t.Fatalf("GetTeam: %v", err)
+	}
+	if len(team.Users) != 1 || team.Users[0] != "u1" {
+		t.Fatalf("expected u1 in default team, got %v", team.Users)
+	}
+
+	if pending, _ = p.ListPendingMembers(ws); len(pending) != 0 {
+		t.Fatalf("expected empty queue, got %d", len(pending))
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-invite"
+	if err := p.CreateWorkspace(ws, "Invite", "invite", "invite.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.CreateRole(ws, "agent", "Agent", "", nil, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if err := p.CreateTeam(ws, "support", "Support", "", nil, false); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+
+	if err := p.CreateBPO(ws, "bpo-1", "BPO", ""); err != nil {
+		t.Fatalf("CreateBPO: %v", err)
+	}
+
+	invitation := rubix.Invitation{ID: "inv1", Email: " New@Example.com ", Type: rubix.MembershipTypeSupport, PartnerID: "bpo-1", Roles: []string{"agent"}, Teams: []string{"support"}, InvitedBy: "owner"}
+	first, err := p.CreateInvitation(ws, invitation, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if _, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv2", Email: "new@example.com"}, 0); !errors.Is(err, rubix.ErrDuplicate) {
+		t.Fatalf("expected duplicate pending invitation, got %v", err)
+	}
+	if _, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv3", Email: "x@example.com", Roles: []string{"missing"}}, 0); err == nil {
+		t.Fatalf("expected unknown role to be rejected")
+	}
+	if _, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv3", Email: "x@example.com", Type: "admin"}, 0); err == nil {
+		t.Fatalf("expected unknown membership type to be rejected")
+	}
+	if _, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv3", Email: "x@example.com", PartnerID: "bpo-missing"}, 0); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected unknown partner to be rejected, got %v", err)
+	}
+
+	token, err := p.ResendInvitation(ws, "inv1", time.Hour)
+	if err != nil {
+		t.Fatalf("ResendInvitation: %v", err)
+	}
+	if token == first {
+		t.Fatalf("expected resend to rotate the token")
+	}
+	if _, err := p.AcceptInvitation(first, "u1"); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected old token to be rejected, got %v", err)
+	}
+
+	stored, err := p.GetInvitation(ws, "inv1")
+	if err != nil {
+		t.Fatalf("GetInvitation: %v", err)
+	}
+	if stored.Email != "new@example.com" || stored.SendCount != 2 || stored.State != rubix.InvitationStatePending {
+		t.Fatalf("unexpected invitation %+v", stored)
+	}
+
+	// Only the invited email address can accept
+	if err := p.CreateUser("u9", "Other", "other@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := p.AcceptInvitation(token, "u9"); !errors.Is(err, rubix.ErrInvitationRecipient) {
+		t.Fatalf("expected another user to be rejected, got %v", err)
+	}
+	if _, err := p.AcceptInvitation(token, "nobody"); !errors.Is(err, rubix.ErrInvitationRecipient) {
+		t.Fatalf("expected unknown user to be rejected, got %v", err)
+	}
+	if err := p.CreateUser("u1", "New", "NEW@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	accepted, err := p.AcceptInvitation(token, "u1")
+	if err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+	if accepted.State != rubix.InvitationStateAccepted || accepted.AcceptedBy != "u1" {
+		t.Fatalf("unexpected accepted invitation %+v", accepted)
+	}
+	if _, err := p.AcceptInvitation(token, "u2"); !errors.Is(err, rubix.ErrInvitationInvalid) {
+		t.Fatalf("expected token to be single use, got %v", err)
+	}
+
+	members, err := p.GetWorkspaceMembers(ws, "u1")
+	if err != nil || len(members) != 1 {
+		t.Fatalf("GetWorkspaceMembers: %v %v", members, err)
+	}
+	m := members[0]
+	if m.State != rubix.MembershipStateActive || m.Source != rubix.MembershipSourceInvite || m.Type != rubix.MembershipTypeSupport || m.PartnerID != "bpo-1" {
+		t.Fatalf("unexpected membership %+v", m)
+	}
+	roles, err := p.GetUserRoles(ws, "u1")
+	if err != nil || len(roles) != 1 || roles[0].Role != "agent" {
+		t.Fatalf("expected agent role, got %v %v", roles, err)
+	}
+	team, err := p.GetTeam(ws, "support")
+	if err != nil {
+		t.Fatalf("GetTeam: %v", err)
+	}
+	if !slices.Contains(team.Users, "u1") {
+		t.Fatalf("expected u1 in support team, got %v", team.Users)
+	}
+
+	// Revoked and expired invitations cannot be accepted
+	revokeToken, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv4", Email: "revoked@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if err := p.RevokeInvitation(ws, "inv4"); err != nil {
+		t.Fatalf("RevokeInvitation: %v", err)
+	}
+	if err := p.RevokeInvitation(ws, "inv4"); !errors.Is(err, rubix.ErrInvitationInvalid) {
+		t.Fatalf("expected second revoke to fail, got %v", err)
+	}
+	if _, err := p.AcceptInvitation(revokeToken, "u3"); !errors.Is(err, rubix.ErrInvitationInvalid) {
+		t.Fatalf("expected revoked invitation to be rejected, got %v", err)
+	}
+
+	expiredToken, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv5", Email: "late@example.com"}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if _, err := p.primaryConnection.Exec("UPDATE workspace_invitations SET expires_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Minute), "inv5"); err != nil {
+		t.Fatalf("expire invitation: %v", err)
+	}
+	if _, err := p.AcceptInvitation(expiredToken, "u4"); !errors.Is(err, rubix.ErrInvitationExpired) {
+		t.Fatalf("expected expired invitation to be rejected, got %v", err)
+	}
+
+	pending, err := p.GetInvitations(ws, rubix.InvitationStatePending)
+	if err != nil {
+		t.Fatalf("GetInvitations: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "inv5" {
+		t.Fatalf("expected only inv5 pending, got %+v", pending)
+	}
+
+	// An invitation overrides an earlier rejection, but does not lift a suspension
+	for user, state := range map[string]rubix.MembershipState{"u6": rubix.MembershipStateRejected, "u7": rubix.MembershipStateSuspended} {
+		if err := p.CreateUser(user, user, user+"@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := p.AddUserToWorkspace(ws, user, rubix.MembershipTypeMember, ""); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+		if err := p.SetMembershipState(ws, user, state); err != nil {
+			t.Fatalf("SetMembershipState: %v", err)
+		}
+	}
+	rejectedToken, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv6", Email: "u6@example.com", Type: rubix.MembershipTypeOwner, PartnerID: "bpo-1"}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if _, err := p.AcceptInvitation(rejectedToken, "u6"); err != nil {
+		t.Fatalf("AcceptInvitation rejected member: %v", err)
+	}
+	// Existing members take the invited partner and a higher type
+	if members, _ := p.GetWorkspaceMembers(ws, "u6"); len(members) != 1 || members[0].State != rubix.MembershipStateActive ||
+		members[0].Type != rubix.MembershipTypeOwner || members[0].PartnerID != "bpo-1" {
+		t.Fatalf("expected rejected member activated as invited, got %+v", members)
+	}
+	// but are never demoted by an invitation
+	demoteToken, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv8", Email: "u6@example.com", Type: rubix.MembershipTypeSupport}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if _, err := p.AcceptInvitation(demoteToken, "u6"); err != nil {
+		t.Fatalf("AcceptInvitation existing member: %v", err)
+	}
+	if members, _ := p.GetWorkspaceMembers(ws, "u6"); len(members) != 1 || members[0].Type != rubix.MembershipTypeOwner {
+		t.Fatalf("expected owner kept, got %+v", members)
+	}
+	suspendedToken, err := p.CreateInvitation(ws, rubix.Invitation{ID: "inv7", Email: "u7@example.com", Type: rubix.MembershipTypeMember}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if _, err := p.AcceptInvitation(suspendedToken, "u7"); !errors.Is(err, rubix.ErrInvitationInvalid) {
+		t.Fatalf("expected suspended member to be refused, got %v", err)
+	}
+	if inv, _ := p.GetInvitation(ws, "inv7"); inv == nil || inv.State != rubix.InvitationStatePending {
+		t.Fatalf("expected refused invitation to stay pending, got %+v", inv)
+	}
+}
+
+func TestMembershipHistory(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-history"
+	if err := p.CreateWorkspace(ws, "History", "history", "history.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.CreateUser("u1", "One", "one@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, "", rubix.MembershipSourceSelfRequest); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.ApproveMember(ws, "u1", "owner"); err != nil {
+		t.Fatalf("ApproveMember: %v", err)
+	}
+	if err := p.SetMembershipState(ws, "u1", rubix.MembershipStateSuspended, rubix.WithChangeActor("owner"), rubix.WithChangeReason("policy breach")); err != nil {
+		t.Fatalf("SetMembershipState: %v", err)
+	}
+	// Unchanged values are not recorded
+	if err := p.SetMembershipState(ws, "u1", rubix.MembershipStateSuspended); err != nil {
+		t.Fatalf("SetMembershipState: %v", err)
+	}
+	if err := p.SetMembershipType(ws, "u1", rubix.MembershipTypeSupport, rubix.WithChangeActor("owner")); err != nil {
+		t.Fatalf("SetMembershipType: %v", err)
+	}
+	if err := p.RemoveUserFromWorkspace(ws, "u1", rubix.WithChangeActor("admin"), rubix.WithChangeReason("left")); err != nil {
+		t.Fatalf("RemoveUserFromWorkspace: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, "", rubix.MembershipSourceAdmin); err != nil {
+		t.Fatalf("AddUserToWorkspace rejoin: %v", err)
+	}
+
+	timeline, err := p.GetMembershipHistory(ws, "u1")
+	if err != nil {
+		t.Fatalf("GetMembershipHistory: %v", err)
+	}
+	type step struct {
+		kind  rubix.MembershipChangeKind
+		to    rubix.MembershipState
+		actor string
+	}
+	expect := []step{
+		{rubix.MembershipChangeJoined, rubix.MembershipStatePending, ""},
+		{rubix.MembershipChangeState, rubix.MembershipStateActive, "owner"},
+		{rubix.MembershipChangeState, rubix.MembershipStateSuspended, "owner"},
+		{rubix.MembershipChangeType, 0, "owner"},
+		{rubix.MembershipChangeState, rubix.MembershipStateRemoved, "admin"},
+		{rubix.MembershipChangeJoined, rubix.MembershipStatePending, ""},
+	}
+	if len(timeline) != len(expect) {
+		t.Fatalf("expected %d changes, got %+v", len(expect), timeline)
+	}
+	for i, e := range expect {
+		got := timeline[i]
+		if got.Kind != e.kind || got.Actor != e.actor || (e.kind != rubix.MembershipChangeType && got.ToState != e.to) {
+			t.Errorf("change %d: expected %+v, got %+v", i, e, got)
+		}
+	}
+	if timeline[0].Reason != string(rubix.MembershipSourceSelfRequest) {
+		t.Errorf("expected join source as reason, got %q", timeline[0].Reason)
+	}
+	if timeline[2].Reason != "policy breach" || timeline[2].FromState != rubix.MembershipStateActive {
+		t.Errorf("unexpected suspension %+v", timeline[2])
+	}
+	if timeline[3].FromType != rubix.MembershipTypeMember || timeline[3].ToType != rubix.MembershipTypeSupport {
+		t.Errorf("unexpected type change %+v", timeline[3])
+	}
+
+	if state, ok := timeline.StateAt(time.Now().Add(time.Minute)); !ok || state != rubix.MembershipStatePending {
+		t.Errorf("expected current state pending, got %v %v", state, ok)
+	}
+	if _, ok := timeline.StateAt(timeline[0].At.Add(-time.Hour)); ok {
+		t.Errorf("expected no state before joining")
+	}
+	now := timeline[len(timeline)-1].At.Add(time.Hour)
+	if d := timeline.TimeInState(rubix.MembershipStatePending, now); d < time.Hour {
+		t.Errorf("expected at least an hour pending, got %v", d)
+	}
+
+	// Changes are numbered consecutively within the workspace
+	rows, err := p.primaryConnection.Query("SELECT seq FROM workspace_membership_history WHERE workspace = ? ORDER BY seq", ws)
+	if err != nil {
+		t.Fatalf("query seq: %v", err)
+	}
+	defer rows.Close()
+	var expected int64 = 1
+	for rows.Next() {
+		var seq int64
+		if err := rows.Scan(&seq); err != nil {
+			t.Fatalf("scan seq: %v", err)
+		}
+		if seq != expected {
+			t.Fatalf("expected seq %d, got %d", expected, seq)
+		}
+		expected++
+	}
+}
+
+func TestOffboardUser(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-offboard"
+	if err := p.CreateWorkspace(ws, "Offboard", "offboard", "offboard.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.CreateUser("u1", "One", "one@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.SetMembershipState(ws, "u1", rubix.MembershipStateActive); err != nil {
+		t.Fatalf("SetMembershipState: %v", err)
+	}
+	if err := p.CreateRole(ws, "agent", "Agent", "", nil, []string{"u1", "u2"}, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if err := p.CreateTeam(ws, "support", "Support", "", map[string]rubix.TeamLevel{"u1": rubix.TeamLevelManager}, false); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if err := p.CreateBPO(ws, "bpo-1", "BPO One", ""); err != nil {
+		t.Fatalf("CreateBPO: %v", err)
+	}
+	if err := p.SetBPOManagers(ws, "bpo-1", []string{"u1"}); err != nil {
+		t.Fatalf("SetBPOManagers: %v", err)
+	}
+	if _, err := p.SetUserStatus(ws, "u1", rubix.UserStatus{State: rubix.UserStateBusy, ID: "status-1"}); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+	if err := p.SetAuthData(ws, "u1", rubix.DataResult{VendorID: "v", AppID: "a", Key: "token", Value: "secret"}, false); err != nil {
+		t.Fatalf("SetAuthData user: %v", err)
+	}
+	if err := p.SetAuthData(ws, "", rubix.DataResult{VendorID: "v", AppID: "a", Key: "shared", Value: "keep"}, false); err != nil {
+		t.Fatalf("SetAuthData workspace: %v", err)
+	}
+	if err := p.CompleteActivationStep(ws, "u1", "v", "a", "welcome"); err != nil {
+		t.Fatalf("CompleteActivationStep: %v", err)
+	}
+	if err := p.CreateWorkspaceUser(ws, rubix.WorkspaceUser{UserID: "u1", Workspace: ws, Email: "one@example.com", OIDCProvider: "idp"}); err != nil {
+		t.Fatalf("CreateWorkspaceUser: %v", err)
+	}
+
+	report, err := p.OffboardUser(ws, "u1", rubix.WithChangeActor("admin"), rubix.WithChangeReason("contract ended"))
+	if err != nil {
+		t.Fatalf("OffboardUser: %v", err)
+	}
+	if !report.MembershipRemoved || report.PreviousState != rubix.MembershipStateActive {
+		t.Errorf("expected membership removed from active, got %+v", report)
+	}
+	if len(report.Roles) != 1 || report.Roles[0].Role != "agent" {
+		t.Errorf("expected agent role in report, got %+v", report.Roles)
+	}
+	if len(report.Teams) != 1 || report.Teams[0].Level != rubix.TeamLevelManager {
+		t.Errorf("expected support team manager in report, got %+v", report.Teams)
+	}
+	if len(report.BPOs) != 1 || len(report.Statuses) != 1 || len(report.ActivationSteps) != 1 || !report.DirectoryEntry {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.AuthData) != 1 || report.AuthData[0] != "v/a/token" {
+		t.Errorf("expected only user auth data removed, got %v", report.AuthData)
+	}
+
+	if roles, _ := p.GetUserRoles(ws, "u1"); len(roles) != 0 {
+		t.Errorf("expected roles removed, got %v", roles)
+	}
+	if roles, _ := p.GetUserRoles(ws, "u2"); len(roles) != 1 {
+		t.Errorf("expected other users untouched, got %v", roles)
+	}
+	if managers, _ := p.GetBPOManagers(ws, "bpo-1"); len(managers) != 0 {
+		t.Errorf("expected bpo managers removed, got %v", managers)
+	}
+	if data, _ := p.GetAuthData(ws, "u1", app.GlobalAppID{VendorID: "v", AppID: "a"}); len(data) != 1 || data[0].Key != "shared" {
+		t.Errorf("expected workspace auth data kept, got %v", data)
+	}
+	if members, _ := p.GetWorkspaceMembers(ws, "u1"); len(members) != 0 {
+		t.Errorf("expected membership removed, got %v", members)
+	}
+
+	// Rejoining starts from a clean slate
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace rejoin: %v", err)
+	}
+	if roles, _ := p.GetUserRoles(ws, "u1"); len(roles) != 0 {
+		t.Errorf("expected no roles after rejoin, got %v", roles)
+	}
+
+	reports, err := p.GetOffboardingReports(ws, "u1")
+	if err != nil {
+		t.Fatalf("GetOffboardingReports: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Reason != "contract ended" || len(reports[0].Roles) != 1 {
+		t.Errorf("unexpected archived reports %+v", reports)
+	}
+
+	timeline, err := p.GetMembershipHistory(ws, "u1")
+	if err != nil {
+		t.Fatalf("GetMembershipHistory: %v", err)
+	}
+	if state, _ := timeline.StateAt(report.At); state != rubix.MembershipStateRemoved {
+		t.Errorf("expected removal in history, got %+v", timeline)
+	}
+}
+
+func TestMembershipExpiry(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-expiry"
+	if err := p.CreateWorkspace(ws, "Expiry", "expiry", "expiry.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for _, u := range []string{"u1", "u2", "u3", "u4"} {
+		if err := p.AddUserToWorkspace(ws, u, rubix.MembershipTypeSupport, ""); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+		if err := p.SetMembershipState(ws, u, rubix.MembershipStateActive); err != nil {
+			t.Fatalf("SetMembershipState: %v", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	if err := p.SetMembershipExpiry(ws, "u1", now.Add(-time.Minute), rubix.MembershipExpirySuspend); err != nil {
+		t.Fatalf("SetMembershipExpiry u1: %v", err)
+	}
+	if err := p.SetMembershipExpiry(ws, "u2", now.Add(-time.Minute), rubix.MembershipExpiryRemove, rubix.WithChangeActor("owner")); err != nil {
+		t.Fatalf("SetMembershipExpiry u2: %v", err)
+	}
+	if err := p.SetMembershipExpiry(ws, "u3", now.Add(48*time.Hour), ""); err != nil {
+		t.Fatalf("SetMembershipExpiry u3: %v", err)
+	}
+	if err := p.SetMembershipExpiry(ws, "u4", now.Add(-time.Minute), rubix.MembershipExpirySuspend); err != nil {
+		t.Fatalf("SetMembershipExpiry u4: %v", err)
+	}
+	if err := p.SetMembershipExpiry(ws, "u1", now, "later"); err == nil {
+		t.Fatalf("expected invalid expiry action to be rejected")
+	}
+	if err := p.SetMembershipExpiry(ws, "missing", now, ""); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound, got %v", err)
+	}
+	// An empty user must not select another member
+	if err := p.SetMembershipExpiry(ws, "", now.Add(time.Hour), ""); err == nil {
+		t.Fatalf("expected empty user to be rejected")
+	}
+	if _, err := p.ExtendMembership(ws, "", time.Hour); err == nil {
+		t.Fatalf("expected empty user to be rejected when extending")
+	}
+	if _, err := p.ExtendMembership(ws, "missing", time.Hour); !errors.Is(err, rubix.ErrNoResultFound) {
+		t.Fatalf("expected ErrNoResultFound when extending, got %v", err)
+	}
+	if err := p.ApproveMember(ws, "", "owner"); err == nil {
+		t.Fatalf("expected empty user approval to be rejected")
+	}
+
+	// Expired members are denied before the sweep runs
+	decision, err := p.CheckWorkspaceAccess(rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u1"}, "", "")
+	if err != nil {
+		t.Fatalf("CheckWorkspaceAccess: %v", err)
+	}
+	if decision.Allowed() {
+		t.Fatalf("expected expired member to be denied, got %+v", decision)
+	}
+
+	// Extending an expired membership counts from now
+	extended, err := p.ExtendMembership(ws, "u4", 24*time.Hour, rubix.WithChangeReason("contract renewed"))
+	if err != nil {
+		t.Fatalf("ExtendMembership: %v", err)
+	}
+	if extended.Before(now.Add(23 * time.Hour)) {
+		t.Fatalf("expected extension from now, got %v", extended)
+	}
+	if _, err := p.ExtendMembership(ws, "u3", 0); err == nil {
+		t.Fatalf("expected non-positive extension to be rejected")
+	}
+
+	expiring, err := p.GetExpiringMembers(ws, 72*time.Hour)
+	if err != nil {
+		t.Fatalf("GetExpiringMembers: %v", err)
+	}
+	if len(expiring) != 2 || expiring[0].UserID != "u4" || expiring[1].UserID != "u3" {
+		t.Fatalf("expected u4 then u3 expiring, got %+v", expiring)
+	}
+	if expiring[1].ExpiryAction != rubix.MembershipExpirySuspend {
+		t.Errorf("expected default suspend action, got %q", expiring[1].ExpiryAction)
+	}
+
+	changed, err := p.SweepExpiredMemberships()
+	if err != nil {
+		t.Fatalf("SweepExpiredMemberships: %v", err)
+	}
+	states := map[string]rubix.MembershipState{}
+	for _, m := range changed {
+		if m.Workspace == ws {
+			states[m.UserID] = m.State
+		}
+	}
+	if len(states) != 2 || states["u1"] != rubix.MembershipStateSuspended || states["u2"] != rubix.MembershipStateRemoved {
+		t.Fatalf("unexpected sweep result %+v", changed)
+	}
+	if again, err := p.SweepExpiredMemberships(); err != nil || len(again) != 0 {
+		t.Fatalf("expected second sweep to be a no-op, got %v %v", again, err)
+	}
+
+	// Rejoining after removal starts without the old expiry
+	if err := p.AddUserToWorkspace(ws, "u2", rubix.MembershipTypeSupport, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace rejoin: %v", err)
+	}
+	if rejoined, _ := p.GetWorkspaceMembers(ws, "u2"); len(rejoined) != 1 || !rejoined[0].ExpiresAt.IsZero() || rejoined[0].ExpiryAction != "" || rejoined[0].State != rubix.MembershipStatePending {
+		t.Errorf("expected rejoined member without expiry, got %+v", rejoined)
+	}
+	if again, err := p.SweepExpiredMemberships(); err != nil || len(again) != 0 {
+		t.Fatalf("expected rejoined member to survive the sweep, got %v %v", again, err)
+	}
+
+	timeline, err := p.GetMembershipHistory(ws, "u4")
+	if err != nil {
+		t.Fatalf("GetMembershipHistory: %v", err)
+	}
+	last := timeline[len(timeline)-1]
+	if last.Kind != rubix.MembershipChangeExpiry || last.Reason != "contract renewed" || !last.ToExpiry.Equal(extended) {
+		t.Errorf("expected extension in history, got %+v", last)
+	}
+
+	// Clearing the expiry
+	if err := p.SetMembershipExpiry(ws, "u3", time.Time{}, ""); err != nil {
+		t.Fatalf("SetMembershipExpiry clear: %v", err)
+	}
+	members, _ := p.GetWorkspaceMembers(ws, "u3")
+	if len(members) != 1 || !members[0].ExpiresAt.IsZero() || members[0].ExpiryAction != "" {
+		t.Errorf("expected expiry cleared, got %+v", members)
+	}
+}
+
+func TestImportMembers(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-import"
+	if err := p.CreateWorkspace(ws, "Import", "import", "import.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.CreateRole(ws, "agent", "Agent", "", nil, nil, rubix.Condition{}, false); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if err := p.CreateTeam(ws, "support", "Support", "", map[string]rubix.TeamLevel{"u1": rubix.TeamLevelOwner}, false); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if err := p.CreateUser("u1", "Original", "original@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u2", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.RemoveUserFromWorkspace(ws, "u2"); err != nil {
+		t.Fatalf("RemoveUserFromWorkspace: %v", err)
+	}
+
+	// Invalid rows stop the whole import
+	invalid := "user_id,email,type,roles,teams\n" +
+		"u3,three@example.com,support,agent,support\n" +
+		",missing@example.com,,,\n" +
+		"u3,not-an-email,boss,ghost,nowhere\n"
+	rows, err := rubix.ReadMemberImportCSV(strings.NewReader(invalid))
+	if err != nil {
+		t.Fatalf("ReadMemberImportCSV: %v", err)
+	}
+	report, err := p.ImportMembers(ws, rows, 0)
+	if err != nil {
+		t.Fatalf("ImportMembers: %v", err)
+	}
+	if report.Results[0].Status != rubix.MemberImportStatusSkipped || report.Count(rubix.MemberImportStatusInvalid) != 2 {
+		t.Fatalf("unexpected validation report %+v", report.Results)
+	}
+	if errs := report.Results[2].Errors; len(errs) != 5 {
+		t.Errorf("expected every problem reported for row 3, got %v", errs)
+	}
+	if members, _ := p.GetWorkspaceMembers(ws, "u3"); len(members) != 0 {
+		t.Fatalf("expected nothing imported, got %+v", members)
+	}
+
+	valid := `[
+		{"userID": "u1", "name": "Ignored", "email": "ignored@example.com", "type": "support", "partnerID": "bpo-1", "roles": ["agent"], "teams": ["support"]},
+		{"userID": "u2", "type": "member"},
+		{"userID": "u3", "name": "Three", "email": "three@example.com", "partnerID": "bpo-1", "roles": ["agent"], "teams": ["support"]}
+	]`
+	rows, err = rubix.ReadMemberImportJSON(strings.NewReader(valid))
+	if err != nil {
+		t.Fatalf("ReadMemberImportJSON: %v", err)
+	}
+	report, err = p.ImportMembers(ws, rows, 2)
+	if err != nil {
+		t.Fatalf("ImportMembers: %v", err)
+	}
+	if !report.Succeeded() {
+		t.Fatalf("expected import to succeed, got %+v", report.Results)
+	}
+	// u1 is still pending, so the row is applied without granting access
+	expect := []rubix.MemberImportStatus{rubix.MemberImportStatusInactive, rubix.MemberImportStatusCreated, rubix.MemberImportStatusCreated}
+	for i, status := range expect {
+		if report.Results[i].Status != status {
+			t.Errorf("row %d: expected %s, got %s", i+1, status, report.Results[i].Status)
+		}
+	}
+	if reason := report.Results[0].Reason; reason != "membership is pending" {
+		t.Errorf("expected pending reason, got %q", reason)
+	}
+
+	members, err := p.GetWorkspaceMembers(ws, "u1", "u2", "u3")
+	if err != nil || len(members) != 3 {
+		t.Fatalf("GetWorkspaceMembers: %v %v", members, err)
+	}
+	byUser := map[string]rubix.Membership{}
+	for _, m := range members {
+		byUser[m.UserID] = m
+	}
+	if m := byUser["u1"]; m.Type != rubix.MembershipTypeSupport || m.PartnerID != "bpo-1" || m.Name != "Original" || m.State != rubix.MembershipStatePending {
+		t.Errorf("unexpected updated member %+v", m)
+	}
+	if m := byUser["u3"]; m.State != rubix.MembershipStateActive || m.Source != rubix.MembershipSourceImport || m.Email != "three@example.com" {
+		t.Errorf("unexpected created member %+v", m)
+	}
+	if m := byUser["u2"]; m.State != rubix.MembershipStateActive {
+		t.Errorf("expected removed member re-added, got %+v", m)
+	}
+	if roles, _ := p.GetUserRoles(ws, "u3"); len(roles) != 1 {
+		t.Errorf("expected u3 role, got %v", roles)
+	}
+	team, err := p.GetTeam(ws, "support")
+	if err != nil {
+		t.Fatalf("GetTeam: %v", err)
+	}
+	for _, m := range team.Members {
+		if m.User == "u1" && m.Level != rubix.TeamLevelOwner {
+			t.Errorf("expected existing team level kept, got %s", m.Level)
+		}
+	}
+	if !slices.Contains(team.Users, "u3") {
+		t.Errorf("expected u3 in team, got %v", team.Users)
+	}
+
+	// Importing the same rows again is idempotent
+	report, err = p.ImportMembers(ws, rows, 0)
+	if err != nil || report.Count(rubix.MemberImportStatusUpdated) != 2 || report.Count(rubix.MemberImportStatusInactive) != 1 {
+		t.Fatalf("expected re-import to update all rows, got %+v %v", report, err)
+	}
+
+	// Suspended members stay suspended and are reported as such
+	if err := p.SetMembershipState(ws, "u3", rubix.MembershipStateSuspended); err != nil {
+		t.Fatalf("SetMembershipState: %v", err)
+	}
+	if report, err = p.ImportMembers(ws, rows[2:], 0); err != nil || report.Results[0].Status != rubix.MemberImportStatusInactive || report.Results[0].Reason != "membership is suspended" {
+		t.Fatalf("expected suspended row reported inactive, got %+v %v", report, err)
+	}
+	if members, _ = p.GetWorkspaceMembers(ws, "u3"); len(members) != 1 || members[0].State != rubix.MembershipStateSuspended {
+		t.Errorf("expected u3 to stay suspended, got %+v", members)
+	}
+
+	// Rows without type or partner leave existing owners and partners alone
+	if err := p.SetMembershipType(ws, "u1", rubix.MembershipTypeOwner); err != nil {
+		t.Fatalf("SetMembershipType: %v", err)
+	}
+	rows, err = rubix.ReadMemberImportCSV(strings.NewReader("user_id,roles\nu1,agent\n"))
+	if err != nil {
+		t.Fatalf("ReadMemberImportCSV: %v", err)
+	}
+	if report, err = p.ImportMembers(ws, rows, 0); err != nil || !report.Succeeded() {
+		t.Fatalf("ImportMembers partial: %+v %v", report, err)
+	}
+	if members, _ = p.GetWorkspaceMembers(ws, "u1"); len(members) != 1 || members[0].Type != rubix.MembershipTypeOwner || members[0].PartnerID != "bpo-1" {
+		t.Errorf("expected owner and partner kept, got %+v", members)
+	}
+}
+
+func TestMemberAttributes(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-attrs"
+	if err := p.CreateWorkspace(ws, "Attrs", "attrs", "attrs.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	for _, u := range []string{"u1", "u2", "u3"} {
+		if err := p.AddUserToWorkspace(ws, u, rubix.MembershipTypeMember, ""); err != nil {
+			t.Fatalf("AddUserToWorkspace: %v", err)
+		}
+	}
+	for _, attr := range []rubix.MemberAttribute{
+		{Key: "employeeNumber", Name: "Employee number", Type: rubix.AttributeTypeString},
+		{Key: "costCentre", Name: "Cost centre", Type: rubix.AttributeTypeEnum, Options: []string{"CC-100", "CC-200"}},
+		{Key: "hireDate", Name: "Hire date", Type: rubix.AttributeTypeDate},
+	} {
+		if err := p.SetMemberAttributeDefinition(ws, attr); err != nil {
+			t.Fatalf("SetMemberAttributeDefinition %s: %v", attr.Key, err)
+		}
+	}
+	if err := p.SetMemberAttributeDefinition(ws, rubix.MemberAttribute{Key: "bad", Type: rubix.AttributeTypeEnum}); err == nil {
+		t.Fatalf("expected enum without options to be rejected")
+	}
+
+	if err := p.SetMemberAttributes(ws, "u1", map[string]any{"employeeNumber": "E1", "costCentre": "CC-100", "hireDate": "2022-01-10"}); err != nil {
+		t.Fatalf("SetMemberAttributes u1: %v", err)
+	}
+	if err := p.SetMemberAttributes(ws, "u2", map[string]any{"costCentre": "CC-200", "hireDate": time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("SetMemberAttributes u2: %v", err)
+	}
+	if err := p.SetMemberAttributes(ws, "u3", map[string]any{"costCentre": "CC-999", "unknown": "x"}); err == nil {
+		t.Fatalf("expected invalid values to be rejected")
+	}
+
+	// Attributes are only loaded on request
+	members, err := p.GetWorkspaceMembers(ws, "u1")
+	if err != nil || len(members) != 1 || members[0].Attributes != nil {
+		t.Fatalf("expected members without attributes: %+v %v", members, err)
+	}
+	members, err = p.GetWorkspaceMembersWithAttributes(ws, "u1")
+	if err != nil || len(members) != 1 {
+		t.Fatalf("GetWorkspaceMembersWithAttributes: %v %v", members, err)
+	}
+	if hired, ok := members[0].Attributes["hireDate"].(time.Time); !ok || hired.Year() != 2022 {
+		t.Errorf("expected typed hire date, got %#v", members[0].Attributes)
+	}
+	resolved, err := p.GetResolvedMembers(ws, rubix.MemberFilter{UserIDs: []string{"u1"}, IncludeAttributes: true})
+	if err != nil || len(resolved) != 1 || resolved[0].Attributes["costCentre"] != "CC-100" {
+		t.Errorf("expected resolved member attributes, got %+v %v", resolved, err)
+	}
+
+	// Filters must name known attributes with values of their type
+	for _, filter := range []rubix.AttributeFilter{
+		{Key: "missing", Operator: rubix.AttributeExists},
+		{Key: "hireDate", Operator: rubix.AttributeGreaterThan, Values: []string{"last year"}},
+	} {
+		if _, err := p.GetResolvedMembers(ws, rubix.MemberFilter{Attributes: []rubix.AttributeFilter{filter}}); err == nil {
+			t.Errorf("expected filter %+v to be rejected", filter)
+		}
+	}
+
+	// Changing the type must keep stored values valid
+	if err := p.SetMemberAttributeDefinition(ws, rubix.MemberAttribute{Key: "costCentre", Type: rubix.AttributeTypeNumber}); err == nil {
+		t.Fatalf("expected type change with invalid values to be rejected")
+	}
+
+	resolved, err = p.GetResolvedMembers(ws, rubix.MemberFilter{Attributes: []rubix.AttributeFilter{
+		{Key: "hireDate", Operator: rubix.AttributeGreaterThan, Values: []string{"2023-01-01"}},
+	}})
+	if err != nil {
+		t.Fatalf("GetResolvedMembers: %v", err)
+	}
+	if len(resolved) != 1 || resolved[0].UserID != "u2" {
+		t.Fatalf("expected only u2 hired after 2023, got %+v", resolved)
+	}
+	resolved, err = p.GetResolvedMembers(ws, rubix.MemberFilter{Attributes: []rubix.AttributeFilter{
+		{Key: "costCentre", Operator: rubix.AttributeMissing},
+	}})
+	if err != nil || len(resolved) != 1 || resolved[0].UserID != "u3" {
+		t.Fatalf("expected only u3 without a cost centre, got %+v %v", resolved, err)
+	}
+
+	// Removing a value and the definition
+	if err := p.SetMemberAttributes(ws, "u1", map[string]any{"employeeNumber": nil}); err != nil {
+		t.Fatalf("SetMemberAttributes remove: %v", err)
+	}
+	if err := p.DeleteMemberAttributeDefinition(ws, "hireDate"); err != nil {
+		t.Fatalf("DeleteMemberAttributeDefinition: %v", err)
+	}
+	members, _ = p.GetWorkspaceMembersWithAttributes(ws, "u1")
+	if len(members[0].Attributes) != 1 || members[0].Attributes["costCentre"] != "CC-100" {
+		t.Errorf("expected only cost centre left, got %#v", members[0].Attributes)
+	}
+
+	report, err := p.OffboardUser(ws, "u2")
+	if err != nil {
+		t.Fatalf("OffboardUser: %v", err)
+	}
+	if len(report.Attributes) != 1 || report.Attributes[0] != "costCentre" {
+		t.Errorf("expected attributes cleared on offboarding, got %v", report.Attributes)
+	}
+}
+
+func TestPrincipalConditions(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-principal"
+	if err := p.CreateWorkspace(ws, "Principal", "principal", "principal.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.SetMemberAttributeDefinition(ws, rubix.MemberAttribute{Key: "department", Type: rubix.AttributeTypeString}); err != nil {
+		t.Fatalf("SetMemberAttributeDefinition: %v", err)
+	}
+	if err := p.SetMemberAttributeDefinition(ws, rubix.MemberAttribute{Key: rubix.PrincipalKeyPartnerID, Type: rubix.AttributeTypeString}); err == nil {
+		t.Fatalf("expected reserved attribute key to be rejected")
+	}
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u2", rubix.MembershipTypeSupport, "bpo-1"); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+	if err := p.SetMemberAttributes(ws, "u1", map[string]any{"department": "sales"}); err != nil {
+		t.Fatalf("SetMemberAttributes: %v", err)
+	}
+	if err := p.SetMemberAttributes(ws, "u2", map[string]any{"department": "support"}); err != nil {
+		t.Fatalf("SetMemberAttributes: %v", err)
+	}
+
+	permQuote := app.NewScopedKey("quote", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	permPartner := app.NewScopedKey("partner", &app.GlobalAppID{VendorID: "v", AppID: "a"})
+	salesOnly := rubix.Condition{Principal: []rubix.AttributeFilter{{Key: "department", Operator: rubix.AttributeEquals, Values: []string{"sales"}}}}
+	partnerOnly := rubix.Condition{Principal: []rubix.AttributeFilter{
+		{Key: rubix.PrincipalKeyPartnerID, Operator: rubix.AttributeExists},
+		{Key: rubix.PrincipalKeyMembershipType, Operator: rubix.AttributeEquals, Values: []string{"support"}},
+	}}
+	if err := p.CreateRole(ws, "quoting", "Quoting", "", []string{permQuote.String()}, []string{"u1", "u2"}, salesOnly, false); err != nil {
+		t.Fatalf("CreateRole quoting: %v", err)
+	}
+	if err := p.CreateRole(ws, "partner", "Partner", "", []string{permPartner.String()}, []string{"u1", "u2"}, partnerOnly, false); err != nil {
+		t.Fatalf("CreateRole partner: %v", err)
+	}
+	if err := p.CreateRole(ws, "broken", "Broken", "", nil, nil, rubix.Condition{Principal: []rubix.AttributeFilter{{Key: "department", Operator: "like"}}}, false); err == nil {
+		t.Fatalf("expected invalid principal condition to be rejected")
+	}
+
+	check := func(user string, perm app.ScopedKey) bool {
+		ok, err := p.UserHasPermission(rubix.Lookup{WorkspaceUUID: ws, UserUUID: user}, perm)
+		if err != nil {
+			t.Fatalf("UserHasPermission %s: %v", user, err)
+		}
+		return ok
+	}
+	if !check("u1", permQuote) || check("u2", permQuote) {
+		t.Errorf("expected only sales to quote")
+	}
+	if check("u1", permPartner) || !check("u2", permPartner) {
+		t.Errorf("expected only the partner support member to have partner access")
+	}
+
+	// A supplied principal is ignored in favour of the stored membership
+	lookup := rubix.Lookup{WorkspaceUUID: ws, UserUUID: "u2", Principal: &rubix.Principal{Attributes: map[string]any{"department": "sales"}}}
+	if ok, err := p.UserHasPermission(lookup, permQuote); err != nil || ok {
+		t.Errorf("expected supplied principal to be ignored, ok=%v err=%v", ok, err)
+	}
+	statements, err := p.GetPermissionStatements(lookup, permQuote)
+	if err != nil {
+		t.Fatalf("GetPermissionStatements: %v", err)
+	}
+	for _, statement := range statements {
+		if statement.Effect == app.PermissionEffectAllow {
+			t.Errorf("expected no allow statement from a supplied principal, got %+v", statement)
+		}
+	}
+
+	decisions, err := p.GetUsersPermissionDecisions(rubix.Lookup{WorkspaceUUID: ws}, []string{"u1", "u2"}, permQuote, permPartner)
+	if err != nil {
+		t.Fatalf("GetUsersPermissionDecisions: %v", err)
+	}
+	if !decisions["u1"].Allowed(permQuote) || decisions["u1"].Allowed(permPartner) || !decisions["u2"].Allowed(permPartner) || decisions["u2"].Allowed(permQuote) {
+		t.Errorf("unexpected batch decisions %+v", decisions)
+	}
+
+	// Changing the attribute changes the outcome
+	if err := p.SetMemberAttributes(ws, "u2", map[string]any{"department": "sales"}); err != nil {
+		t.Fatalf("SetMemberAttributes: %v", err)
+	}
+	if !check("u2", permQuote) {
+		t.Errorf("expected u2 to quote after moving to sales")
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	p := newTestProvider(t)
+	defer func() { _ = p.Close() }()
+
+	ws := "ws-profile"
+	if err := p.CreateWorkspace(ws, "Profile", "profile", "profile.local"); err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	if err := p.CreateUser("u1", "One", "one@example.com"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := p.AddUserToWorkspace(ws, "u1", rubix.MembershipTypeMember, ""); err != nil {
+		t.Fatalf("AddUserToWorkspace: %v", err)
+	}
+
+	user, err := p.GetUser(ws, "u1")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Locale != "" || user.TimeZone != "" || user.Preferences != nil {
+		t.Errorf("expected empty profile, got %+v", user.UserProfile)
+	}
+
+	if err := p.MutateUser(ws, "u1", rubix.WithUserTimeZone("Mars/Olympus")); err == nil {
+		t.Fatalf("expected unknown time zone to be rejected")
+	}
+	if err := p.MutateUser(ws, "u1",
+		rubix.WithUserLocale("en-gb"),
+		rubix.WithUserTimeZone("Asia/Tokyo"),
+		rubix.WithUserAvatarURL("https://cdn.example.com/u1.png"),
+		rubix.WithUserPhone("+447700900123"),
+		rubix.WithUserPreference("theme", "dark"),
+		rubix.WithUserPreference("density", "compact"),
+	); err != nil {
+		t.Fatalf("MutateUser: %v", err)
+	}
+	// Preferences merge, an empty value removes the key
+	if err := p.MutateUser(ws, "u1", rubix.WithUserPreference("density", ""), rubix.WithUserPreference("dateFormat", "dmy")); err != nil {
+		t.Fatalf("MutateUser preferences: %v", err)
+	}
+
+	user, err = p.GetUser(ws, "u1")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	want := rubix.UserProfile{
+		Locale:      "en-GB",
+		TimeZone:    "Asia/Tokyo",
+		AvatarURL:   "https://cdn.example.com/u1.png",
+		Phone:       "+447700900123",
+		Preferences: map[string]string{"theme": "dark", "dateFormat": "dmy"},
+	}
+	if !reflect.DeepEqual(user.UserProfile, want) || user.Name != "One" {
+		t.Errorf("GetUser profile = %+v, want %+v", user.UserProfile, want)
+	}
+
+	members, err := p.GetWorkspaceMembers(ws, "u1")
+	if err != nil || len(members) != 1 {
+		t.Fatalf("GetWorkspaceMembers: %v %+v", err, members)
+	}
+	if !reflect.DeepEqual(members[0].UserProfile, want) {
+		t.Errorf("member profile = %+v, want %+v", members[0].UserProfile, want)
+	}
+
+	// Clear at end of day uses the user's time zone
+	if _, err := p.SetUserStatus(ws, "u1", rubix.UserStatus{State: rubix.UserStateBusy, ClearEndOfDay: true}); err != nil {
+		t.Fatalf("SetUserStatus: %v", err)
+	}
+	status, err := p.GetUserStatus(ws, "u1")
+	if err != nil {
+		t.Fatalf("GetUserStatus: %v", err)
+	}
+	if wantExpiry := want.EndOfDay(time.Now()); !status.ExpiryTime.Equal(wantExpiry) {
+		t.Errorf("status expiry = %v, want %v", status.ExpiryTime, wantExpiry)
+	}
+}
@@ -0,0 +1,325 @@
+package sql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// DefaultInvitationTTL is used when an invitation is created or resent without an expiry
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+const invitationColumns = "workspace, id, email, type, partner_id, roles, teams, invited_by, state, created_at, expires_at, last_sent_at, send_count, accepted_by, accepted_at"
+
+// CreateInvitation stores a pending invitation and returns its token, which is not retrievable later
+func (p *Provider) CreateInvitation(workspace string, invitation rubix.Invitation, ttl time.Duration) (string, error) {
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	if invitation.ID == "" || invitation.Email == "" {
+		return "", errors.New("invitation requires an id and email")
+	}
+	switch invitation.Type {
+	case "":
+		invitation.Type = rubix.MembershipTypeMember
+	case rubix.MembershipTypeOwner, rubix.MembershipTypeMember, rubix.MembershipTypeSupport:
+	default:
+		return "", fmt.Errorf("invalid membership type %q", invitation.Type)
+	}
+	if invitation.PartnerID != "" {
+		if _, err := p.GetBPO(workspace, invitation.PartnerID); err != nil {
+			return "", fmt.Errorf("partner %q: %w", invitation.PartnerID, err)
+		}
+	}
+	for _, role := range invitation.Roles {
+		if _, err := p.GetRole(workspace, role); err != nil {
+			return "", err
+		}
+	}
+	for _, team := range invitation.Teams {
+		if _, err := p.GetTeam(workspace, team); err != nil {
+			return "", err
+		}
+	}
+
+	var pending int
+	if err := p.primaryConnection.QueryRow("SELECT COUNT(*) FROM workspace_invitations WHERE workspace = ? AND email = ? AND state = ? AND expires_at > ?",
+		workspace, invitation.Email, rubix.InvitationStatePending, time.Now().UTC()).Scan(&pending); err != nil {
+		return "", err
+	}
+	if pending > 0 {
+		return "", rubix.ErrDuplicate
+	}
+
+	token, hash, err := newInvitationToken()
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 {
+		ttl = DefaultInvitationTTL
+	}
+	rolesBytes, _ := json.Marshal(invitation.Roles)
+	teamsBytes, _ := json.Marshal(invitation.Teams)
+	now := time.Now().UTC()
+
+	_, err = p.primaryConnection.Exec(
+		"INSERT INTO workspace_invitations (workspace, id, email, type, partner_id, roles, teams, invited_by, state, created_at, expires_at, last_sent_at, send_count, token_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		workspace, invitation.ID, invitation.Email, invitation.Type, invitation.PartnerID, string(rolesBytes), string(teamsBytes), invitation.InvitedBy,
+		rubix.InvitationStatePending, now, now.Add(ttl), now, 1, hash,
+	)
+	if p.isDuplicateConflict(err) {
+		return "", rubix.ErrDuplicate
+	}
+	if err != nil {
+		return "", err
+	}
+	p.update()
+	return token, nil
+}
+
+func (p *Provider) GetInvitation(workspace, id string) (*rubix.Invitation, error) {
+	rows, err := p.primaryConnection.Query("SELECT "+invitationColumns+" FROM workspace_invitations WHERE workspace = ? AND id = ?", workspace, id)
+	if err != nil {
+		return nil, err
+	}
+	invitations, err := scanInvitations(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(invitations) == 0 {
+		return nil, rubix.ErrNoResultFound
+	}
+	return &invitations[0], nil
+}
+
+// GetInvitations returns the workspace invitations, newest first, optionally limited to the given states
+func (p *Provider) GetInvitations(workspace string, states ...rubix.InvitationState) ([]rubix.Invitation, error) {
+	query := "SELECT " + invitationColumns + " FROM workspace_invitations WHERE workspace = ?"
+	args := []any{workspace}
+	if len(states) > 0 {
+		query += " AND state IN (?" + strings.Repeat(",?", len(states)-1) + ")"
+		for _, state := range states {
+			args = append(args, state)
+		}
+	}
+	rows, err := p.primaryConnection.Query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanInvitations(rows)
+}
+
+func scanInvitations(rows *sql.Rows) ([]rubix.Invitation, error) {
+	defer rows.Close()
+	var invitations []rubix.Invitation
+	for rows.Next() {
+		var inv rubix.Invitation
+		roles := sql.NullString{}
+		teams := sql.NullString{}
+		createdAt := sql.NullString{}
+		expiresAt := sql.NullString{}
+		lastSentAt := sql.NullString{}
+		acceptedAt := sql.NullString{}
+		if err := rows.Scan(&inv.Workspace, &inv.ID, &inv.Email, &inv.Type, &inv.PartnerID, &roles, &teams, &inv.InvitedBy, &inv.State,
+			&createdAt, &expiresAt, &lastSentAt, &inv.SendCount, &inv.AcceptedBy, &acceptedAt); err != nil {
+			return nil, err
+		}
+		if roles.Valid {
+			json.Unmarshal([]byte(roles.String), &inv.Roles)
+		}
+		if teams.Valid {
+			json.Unmarshal([]byte(teams.String), &inv.Teams)
+		}
+		inv.CreatedAt = timeFromString(createdAt.String)
+		inv.ExpiresAt = timeFromString(expiresAt.String)
+		if lastSentAt.Valid {
+			inv.LastSentAt = timeFromString(lastSentAt.String)
+		}
+		if acceptedAt.Valid {
+			inv.AcceptedAt = timeFromString(acceptedAt.String)
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// ResendInvitation issues a new token for a pending invitation and extends its expiry.
+// Tokens from earlier sends stop working.
+func (p *Provider) ResendInvitation(workspace, id string, ttl time.Duration) (string, error) {
+	token, hash, err := newInvitationToken()
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 {
+		ttl = DefaultInvitationTTL
+	}
+	now := time.Now().UTC()
+	res, err := p.primaryConnection.Exec("UPDATE workspace_invitations SET token_hash = ?, expires_at = ?, last_sent_at = ?, send_count = send_count + 1 WHERE workspace = ? AND id = ? AND state = ?",
+		hash, now.Add(ttl), now, workspace, id, rubix.InvitationStatePending)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", p.invitationNotPending(workspace, id)
+	}
+	p.update()
+	return token, nil
+}
+
+func (p *Provider) RevokeInvitation(workspace, id string) error {
+	res, err := p.primaryConnection.Exec("UPDATE workspace_invitations SET state = ? WHERE workspace = ? AND id = ? AND state = ?",
+		rubix.InvitationStateRevoked, workspace, id, rubix.InvitationStatePending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return p.invitationNotPending(workspace, id)
+	}
+	p.update()
+	return nil
+}
+
+// AcceptInvitation redeems the token for the user, creating an active membership with the invited
+// type, partner ID, roles and teams. Each token can be accepted once, only by a user with the invited
+// email address. Pending and rejected members are activated, suspended and archived members cannot accept.
+func (p *Provider) AcceptInvitation(token, user string) (*rubix.Invitation, error) {
+	rows, err := p.primaryConnection.Query("SELECT "+invitationColumns+" FROM workspace_invitations WHERE token_hash = ?", hashInvitationToken(token))
+	if err != nil {
+		return nil, err
+	}
+	invitations, err := scanInvitations(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(invitations) == 0 {
+		return nil, rubix.ErrNoResultFound
+	}
+	invitation := invitations[0]
+	now := time.Now().UTC()
+	if invitation.State != rubix.InvitationStatePending {
+		return nil, rubix.ErrInvitationInvalid
+	}
+	if invitation.Expired(now) {
+		return nil, rubix.ErrInvitationExpired
+	}
+	if err = p.checkInvitationRecipient(invitation, user); err != nil {
+		return nil, err
+	}
+
+	res, err := p.primaryConnection.Exec("UPDATE workspace_invitations SET state = ?, accepted_by = ?, accepted_at = ? WHERE workspace = ? AND id = ? AND state = ? AND token_hash = ?",
+		rubix.InvitationStateAccepted, user, now, invitation.Workspace, invitation.ID, rubix.InvitationStatePending, hashInvitationToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, rubix.ErrInvitationInvalid
+	}
+
+	if err = p.applyInvitation(invitation, user); err != nil {
+		// Leave the invitation redeemable so the user can try again
+		_, _ = p.primaryConnection.Exec("UPDATE workspace_invitations SET state = ?, accepted_by = '', accepted_at = NULL WHERE workspace = ? AND id = ?",
+			rubix.InvitationStatePending, invitation.Workspace, invitation.ID)
+		return nil, err
+	}
+
+	invitation.State = rubix.InvitationStateAccepted
+	invitation.AcceptedBy = user
+	invitation.AcceptedAt = now
+	p.update()
+	return &invitation, nil
+}
+
+// applyInvitation makes the user a member with the invited type, partner, roles and teams.
+// Existing members are activated and take the invited partner, and the type only when it ranks higher.
+func (p *Provider) applyInvitation(invitation rubix.Invitation, user string) error {
+	if err := p.AddUserToWorkspace(invitation.Workspace, user, invitation.Type, invitation.PartnerID, rubix.MembershipSourceInvite); err != nil {
+		return err
+	}
+
+	member, err := p.getWorkspaceMember(invitation.Workspace, user)
+	if err != nil {
+		return err
+	}
+	reason := rubix.WithChangeReason("accepted invitation " + invitation.ID)
+
+	// The invitation is the approval, overriding an earlier rejection
+	if member.State == rubix.MembershipStatePending || member.State == rubix.MembershipStateRejected {
+		if err := p.SetMembershipState(invitation.Workspace, user, rubix.MembershipStateActive, rubix.WithChangeActor(user), reason); err != nil {
+			return err
+		}
+	}
+	// An invitation can promote an existing member but never demote one
+	if invitation.Type.Int() > member.Type.Int() {
+		if err := p.SetMembershipType(invitation.Workspace, user, invitation.Type, rubix.WithChangeActor(user), reason); err != nil {
+			return err
+		}
+	}
+	if invitation.PartnerID != "" && invitation.PartnerID != member.PartnerID {
+		if err := p.SetMemberPartnerID(invitation.Workspace, user, invitation.PartnerID); err != nil {
+			return err
+		}
+	}
+
+	if len(invitation.Roles) > 0 {
+		if err := p.MutateUser(invitation.Workspace, user, rubix.WithRolesToAdd(invitation.Roles...)); err != nil {
+			return err
+		}
+	}
+	for _, team := range invitation.Teams {
+		if err := p.MutateTeam(invitation.Workspace, team, rubix.WithTeamUsersToAdd(rubix.TeamLevelMember, user)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkInvitationRecipient ensures the user holds the invited email address and is not a suspended
+// or archived member, whose access an invitation must not restore
+func (p *Provider) checkInvitationRecipient(invitation rubix.Invitation, user string) error {
+	email := sql.NullString{}
+	err := p.primaryConnection.QueryRow("SELECT email FROM users WHERE user = ?", user).Scan(&email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if !strings.EqualFold(strings.TrimSpace(email.String), invitation.Email) {
+		return rubix.ErrInvitationRecipient
+	}
+
+	state, _, found, err := p.currentMembership(invitation.Workspace, user)
+	if err != nil {
+		return err
+	}
+	if found && (state == rubix.MembershipStateSuspended || state == rubix.MembershipStateArchived) {
+		return fmt.Errorf("membership is %s: %w", strings.ToLower(state.Display()), rubix.ErrInvitationInvalid)
+	}
+	return nil
+}
+
+// invitationNotPending explains why a pending-only operation matched no invitation
+func (p *Provider) invitationNotPending(workspace, id string) error {
+	if _, err := p.GetInvitation(workspace, id); err != nil {
+		return err
+	}
+	return rubix.ErrInvitationInvalid
+}
+
+func newInvitationToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashInvitationToken(token), nil
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package sql
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// ipMatcherTTL bounds how long changes made through another provider instance go unseen
+const ipMatcherTTL = time.Minute
+
+// ipMatcherCache holds each workspace's IP groups compiled into matchers,
+// dropped whenever a group is created, mutated or deleted through this provider
+type ipMatcherCache struct {
+	mu         sync.Mutex
+	generation uint64
+	workspaces map[string]compiledWorkspace
+}
+
+type compiledWorkspace struct {
+	groups   rubix.CompiledIPGroups
+	compiled time.Time
+}
+
+// compiledIPGroups returns the workspace IP groups compiled for matching, loading them on first use.
+// Load errors are returned rather than cached, as evaluating without the groups would skip allowed-group checks.
+func (p *Provider) compiledIPGroups(workspace string) (rubix.CompiledIPGroups, error) {
+	c := &p.ipMatchers
+	c.mu.Lock()
+	if cached, ok := c.workspaces[workspace]; ok && time.Since(cached.compiled) < ipMatcherTTL {
+		c.mu.Unlock()
+		return cached.groups, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	groups, err := p.GetIPGroups(workspace)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		entries[g.ID] = g.Entries
+	}
+	lookup := func(groupID string) []string { return entries[groupID] }
+	compiled := make(rubix.CompiledIPGroups, len(groups))
+	for _, g := range groups {
+		compiled[g.ID] = rubix.NewIPMatcher(rubix.ExpandIPGroupEntries(g.ID, lookup))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Skip caching when a group changed while loading, the next call will reload
+	if c.generation == generation {
+		if c.workspaces == nil {
+			c.workspaces = make(map[string]compiledWorkspace)
+		}
+		c.workspaces[workspace] = compiledWorkspace{groups: compiled, compiled: time.Now()}
+	}
+	return compiled, nil
+}
+
+func (p *Provider) invalidateIPGroups(workspace string) {
+	c := &p.ipMatchers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.workspaces, workspace)
+}
+
+// checkIPGroupReferences rejects entries referencing unknown groups, or that would make the group
+// reference itself, directly or through other groups
+func (p *Provider) checkIPGroupReferences(workspace, groupID string, entries []string) error {
+	var references []string
+	for _, entry := range entries {
+		if id, ok := rubix.IPGroupReference(entry); ok {
+			references = append(references, id)
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+
+	groups, err := p.GetIPGroups(workspace)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		existing[g.ID] = g.Entries
+	}
+	existing[groupID] = entries
+	for _, id := range references {
+		if _, ok := existing[id]; !ok {
+			return fmt.Errorf("ip group %q: %w", id, rubix.ErrNoResultFound)
+		}
+	}
+	if rubix.IPGroupReferencesCycle(groupID, func(id string) []string { return existing[id] }) {
+		return rubix.ErrIPGroupCycle
+	}
+	return nil
+}
+
+// ipGroupReferrers returns the other groups whose entries reference the group
+func (p *Provider) ipGroupReferrers(workspace, groupID string) ([]string, error) {
+	groups, err := p.GetIPGroups(workspace)
+	if err != nil {
+		return nil, err
+	}
+	var referrers []string
+	for _, g := range groups {
+		if g.ID == groupID {
+			continue
+		}
+		for _, entry := range g.Entries {
+			if id, ok := rubix.IPGroupReference(entry); ok && id == groupID {
+				referrers = append(referrers, g.ID)
+				break
+			}
+		}
+	}
+	return referrers, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -47,6 +48,10 @@ func (p *Provider) isDuplicateConflict(err error) bool {
 	return false
 }
 
+// membershipRejoinReset clears the expiry and approval decision of a removed membership being rejoined,
+// so the returning member is neither already expired nor carrying a previous decision
+const membershipRejoinReset = "expires_at = NULL, expiry_action = '', decided_by = '', decided_at = NULL, decision_reason = ''"
+
 func (p *Provider) AddUserToWorkspace(workspaceID, userID string, as rubix.MembershipType, partnerId string, source ...rubix.MembershipSource) error {
 	src := ""
 	if len(source) > 0 {
@@ -56,8 +61,29 @@ func (p *Provider) AddUserToWorkspace(workspaceID, userID string, as rubix.Membe
 	_, err = p.primaryConnection.Exec("INSERT INTO workspace_memberships (user, workspace, type, since, state_since, state, partner_id, source) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)", userID, workspaceID, as, rubix.MembershipStatePending, partnerId, src)
 
 	if p.isDuplicateConflict(err) {
-		_, err = p.primaryConnection.Exec("UPDATE workspace_memberships SET state_since = CURRENT_TIMESTAMP, state = ?, type = ?, partner_id = ?, source = ? WHERE state = ? AND user = ? AND workspace = ?", rubix.MembershipStatePending, as, partnerId, src, rubix.MembershipStateRemoved, userID, workspaceID)
-		return err
+		var res sql.Result
+		res, err = p.primaryConnection.Exec("UPDATE workspace_memberships SET state_since = CURRENT_TIMESTAMP, state = ?, type = ?, partner_id = ?, source = ?, "+membershipRejoinReset+" WHERE state = ? AND user = ? AND workspace = ?", rubix.MembershipStatePending, as, partnerId, src, rubix.MembershipStateRemoved, userID, workspaceID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		if err = p.recordMembershipChange(rubix.MembershipChange{
+			Workspace: workspaceID, User: userID, Kind: rubix.MembershipChangeJoined,
+			FromState: rubix.MembershipStateRemoved, ToState: rubix.MembershipStatePending, ToType: as, Reason: src,
+		}); err != nil {
+			return err
+		}
+	} else if err == nil {
+		err = p.recordMembershipChange(rubix.MembershipChange{
+			Workspace: workspaceID, User: userID, Kind: rubix.MembershipChangeJoined,
+			ToState: rubix.MembershipStatePending, ToType: as, Reason: src,
+		})
+	}
+	// Self requested memberships follow the approval mode for the member's email domain
+	if err == nil && src == string(rubix.MembershipSourceSelfRequest) {
+		err = p.autoApproveMember(workspaceID, userID)
 	}
 	p.update()
 	return err
@@ -111,7 +137,7 @@ func (p *Provider) GetWorkspaceMembers(workspaceUuid string, userIDs ...string)
 		}
 	}
 
-	q := "SELECT m.user, m.type, m.partner_id, m.since, m.state, m.state_since, m.source, u.name, u.email " +
+	q := "SELECT m.user, m.type, m.partner_id, m.since, m.state, m.state_since, m.source, m.decided_by, m.decided_at, m.decision_reason, m.expires_at, m.expiry_action, u.name, u.email, " + userProfileColumns + " " +
 		"FROM workspace_memberships AS m " +
 		"LEFT JOIN users AS u ON m.user = u.user " +
 		"WHERE " + strings.Join(fields, " AND ")
@@ -130,12 +156,25 @@ func (p *Provider) GetWorkspaceMembers(workspaceUuid string, userIDs ...string)
 		since := sql.NullString{}
 		stateSince := sql.NullString{}
 		source := sql.NullString{}
-		if scanErr := rows.Scan(&member.UserID, &member.Type, &member.PartnerID, &since, &member.State, &stateSince, &source, &name, &email); scanErr != nil {
+		decidedAt := sql.NullString{}
+		decisionReason := sql.NullString{}
+		expiresAt := sql.NullString{}
+		profile := profileScanner{}
+		dest := []any{&member.UserID, &member.Type, &member.PartnerID, &since, &member.State, &stateSince, &source, &member.DecidedBy, &decidedAt, &decisionReason, &expiresAt, &member.ExpiryAction, &name, &email}
+		if scanErr := rows.Scan(append(dest, profile.dest()...)...); scanErr != nil {
 			return nil, scanErr
 		} else {
 			member.Email = email.String
 			member.Name = name.String
+			member.UserProfile = profile.profile()
 			member.Source = rubix.MembershipSource(source.String)
+			member.DecisionReason = decisionReason.String
+			if decidedAt.Valid {
+				member.DecidedAt = timeFromString(decidedAt.String)
+			}
+			if expiresAt.Valid {
+				member.ExpiresAt = timeFromString(expiresAt.String)
+			}
 
 			if stateSince.Valid && stateSince.String != "" {
 				member.StateSince, _ = time.Parse(time.RFC3339Nano, stateSince.String)
@@ -147,9 +186,30 @@ func (p *Provider) GetWorkspaceMembers(workspaceUuid string, userIDs ...string)
 		}
 		members = append(members, member)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 	return members, nil
 }
 
+// getWorkspaceMember returns the membership of a single user. GetWorkspaceMembers lists every
+// member when given no user, so an empty user is rejected and only an exact match is returned.
+func (p *Provider) getWorkspaceMember(workspace, user string) (*rubix.Membership, error) {
+	if user == "" {
+		return nil, errors.New("user is required")
+	}
+	members, err := p.GetWorkspaceMembers(workspace, user)
+	if err != nil {
+		return nil, err
+	}
+	for i := range members {
+		if members[i].UserID == user {
+			return &members[i], nil
+		}
+	}
+	return nil, rubix.ErrNoResultFound
+}
+
 func (p *Provider) RetrieveWorkspace(workspaceUuid string) (*rubix.Workspace, error) {
 	return p.retrieveWorkspaceBy("uuid", workspaceUuid)
 }
@@ -194,7 +254,7 @@ func (p *Provider) retrieveWorkspaceBy(field, match string) (*rubix.Workspace, e
 
 func (p *Provider) retrieveWorkspacesByQuery(where string, args ...any) (map[string]*rubix.Workspace, error) {
 	resp := make(map[string]*rubix.Workspace)
-	rows, err := p.primaryConnection.Query("SELECT uuid, alias, domain, name, icon, installedApplications,defaultApp,systemVendors,footerParts,accessCondition,emailDomainWhitelist,memberApprovalMode,emailDomainApproval FROM workspaces WHERE "+where, args...)
+	rows, err := p.primaryConnection.Query("SELECT uuid, alias, domain, name, icon, installedApplications,defaultApp,systemVendors,footerParts,accessCondition,emailDomainWhitelist,memberApprovalMode,emailDomainApproval,accessRequestApprover FROM workspaces WHERE "+where, args...)
 	if err != nil {
 		return resp, err
 	}
@@ -211,7 +271,7 @@ func (p *Provider) retrieveWorkspacesByQuery(where string, args ...any) (map[str
 		sysVendors := sql.NullString{}
 		icon := sql.NullString{}
 		defaultApp := sql.NullString{}
-		scanErr := rows.Scan(&located.Uuid, &located.Alias, &located.Domain, &located.Name, &icon, &installedApplicationsJson, &defaultApp, &sysVendors, &metricTickersJson, &accessConditionJson, &emailDomainWhitelistJson, &memberApprovalMode, &emailDomainApprovalJson)
+		scanErr := rows.Scan(&located.Uuid, &located.Alias, &located.Domain, &located.Name, &icon, &installedApplicationsJson, &defaultApp, &sysVendors, &metricTickersJson, &accessConditionJson, &emailDomainWhitelistJson, &memberApprovalMode, &emailDomainApprovalJson, &located.AccessRequestApprover)
 		if scanErr != nil {
 			continue
 		}
@@ -237,6 +297,9 @@ func (p *Provider) retrieveWorkspacesByQuery(where string, args ...any) (map[str
 }
 
 func (p *Provider) SetWorkspaceAccessCondition(workspaceUuid string, condition rubix.Condition) error {
+	if err := condition.Validate(); err != nil {
+		return err
+	}
 	conditionBytes, err := json.Marshal(condition)
 	if err != nil {
 		return err
@@ -276,8 +339,8 @@ func (p *Provider) SetWorkspaceEmailDomainApproval(workspaceUuid string, approva
 }
 
 func (p *Provider) SetWorkspaceMemberApprovalMode(workspaceUuid string, mode string) error {
-	if mode != "queue" {
-		mode = "auto"
+	if mode != rubix.MemberApprovalModeQueue {
+		mode = rubix.MemberApprovalModeAuto
 	}
 	_, err := p.primaryConnection.Exec("UPDATE workspaces SET memberApprovalMode = ? WHERE uuid = ?", mode, workspaceUuid)
 	if err != nil {
@@ -287,6 +350,15 @@ func (p *Provider) SetWorkspaceMemberApprovalMode(workspaceUuid string, mode str
 	return nil
 }
 
+func (p *Provider) SetWorkspaceAccessRequestApprover(workspaceUuid string, permission string) error {
+	_, err := p.primaryConnection.Exec("UPDATE workspaces SET accessRequestApprover = ? WHERE uuid = ?", permission, workspaceUuid)
+	if err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
 func (p *Provider) SetWorkspaceName(workspaceUuid, name string) error {
 	_, err := p.primaryConnection.Exec("UPDATE workspaces SET name = ? WHERE uuid = ?", name, workspaceUuid)
 	if err != nil {
@@ -476,77 +548,28 @@ func (p *Provider) GetPermissionStatements(lookup rubix.Lookup, permissions ...a
 		return nil, nil
 	}
 
-	params := []interface{}{lookup.UserUUID, lookup.WorkspaceUUID}
+	keys := make([]string, 0, len(permissions))
 	for _, perm := range permissions {
-		params = append(params, perm.String())
+		keys = append(keys, perm.String())
 	}
 
-	query := "SELECT rp.permission,rp.resource, rp.allow, r.conditions, rp.options" +
-		" FROM user_roles AS ur" +
-		" INNER JOIN roles AS r ON ur.role = r.role AND ur.workspace = r.workspace" +
-		" INNER JOIN role_permissions AS rp ON rp.role = r.role AND rp.workspace = r.workspace" +
-		" WHERE rp.resource = '' " + // Resource not supported in query
-		" AND ur.user = ? AND ur.workspace = ?" +
-		" AND rp.permission IN (?" + strings.Repeat(",?", len(permissions)-1) + ")"
-
-	rows, err := p.primaryConnection.Query(query, params...)
+	model, err := p.loadPermissionModel(lookup.WorkspaceUUID, []string{lookup.UserUUID}, keys)
 	if err != nil {
 		return nil, err
 	}
-
-	defer rows.Close()
-	result := make(map[string]permissionResult)
-	for rows.Next() {
-		newResult := permissionResult{}
-		var roleConditionsStr sql.NullString
-		var optionsStr sql.NullString
-		if err = rows.Scan(&newResult.PermissionKey, &newResult.Resource, &newResult.Allow, &roleConditionsStr, &optionsStr); err != nil {
+	if model.requiresPrincipal() {
+		lookups := []rubix.Lookup{lookup}
+		if err = p.loadPrincipals(lookup.WorkspaceUUID, lookups); err != nil {
 			return nil, err
 		}
-
-		if roleConditionsStr.Valid {
-			if err = json.Unmarshal([]byte(roleConditionsStr.String), &newResult.RoleConditions); err != nil {
-				return nil, err
-			}
-		}
-
-		if optionsStr.Valid {
-			if err = json.Unmarshal([]byte(optionsStr.String), &newResult.Options); err != nil {
-				return nil, err
-			}
-		}
-
-		if _, ok := result[newResult.PermissionKey]; !ok || !newResult.Allow {
-			result[newResult.PermissionKey] = newResult
-		} else if newResult.Options != nil && len(newResult.Options) > 0 {
-			for key, opt := range newResult.Options {
-				if _, ok = result[newResult.PermissionKey].Options[key]; !ok {
-					result[newResult.PermissionKey].Options[key] = opt
-				} else {
-					result[newResult.PermissionKey].Options[key] = append(result[newResult.PermissionKey].Options[key], newResult.Options[key]...)
-				}
-			}
-		}
+		lookup = lookups[0]
 	}
 
-	var statements []app.PermissionStatement
-	for _, res := range result {
-		effect := app.PermissionEffectAllow
-		if !res.Allow {
-			effect = app.PermissionEffectDeny
-		} else if !rubix.CheckCondition(res.RoleConditions, lookup, p.ipGroupResolver(lookup.WorkspaceUUID)) {
-			continue
-		}
-
-		statements = append(statements, app.PermissionStatement{
-			Effect:     effect,
-			Permission: app.ScopedKeyFromString(res.PermissionKey),
-			Resource:   "",
-			Meta:       res.Options,
-		})
+	resolvers, err := p.conditionResolvers(lookup.WorkspaceUUID)
+	if err != nil {
+		return nil, err
 	}
-
-	return statements, nil
+	return permissionStatements(model.userGrants(lookup.UserUUID), lookup, resolvers...), nil
 }
 
 func (p *Provider) MutateUser(workspace, user string, options ...rubix.MutateUserOption) error {
@@ -559,43 +582,30 @@ func (p *Provider) MutateUser(workspace, user string, options ...rubix.MutateUse
 	for _, opt := range options {
 		opt(&payload)
 	}
+	if err := payload.ValidateProfile(); err != nil {
+		return err
+	}
 
 	g := errgroup.Group{}
 
-	// Update user name/email in users table
-	if payload.Name != nil || payload.Email != nil {
+	// Update user name, email and profile in users table
+	if payload.HasProfile() {
 		g.Go(func() error {
-			var fields []string
-			var vals []any
-			if payload.Name != nil {
-				fields = append(fields, "name = ?")
-				vals = append(vals, *payload.Name)
-			}
-			if payload.Email != nil {
-				fields = append(fields, "email = ?")
-				vals = append(vals, *payload.Email)
-			}
-			if len(fields) > 0 {
-				vals = append(vals, user)
-				_, err := p.primaryConnection.Exec("UPDATE users SET "+strings.Join(fields, ", ")+" WHERE user = ?", vals...)
-				return err
-			}
-			return nil
+			return p.updateUserProfile(user, payload)
 		})
 	}
 
 	g.Go(func() error {
 
 		for _, role := range payload.RolesToAdd {
-			_, err := p.primaryConnection.Exec("INSERT INTO user_roles (workspace, user, role) VALUES (?, ?, ?)", workspace, user, role)
-
-			if p.isDuplicateConflict(err) {
-				// No change occurred; skip timestamp update
-				continue
-			}
+			added, err := p.grantRole(workspace, user, role, payload.RoleExpiry[role], payload.ShortenExpiry)
 			if err != nil {
 				return err
 			}
+			if !added {
+				// No change occurred; skip timestamp update
+				continue
+			}
 			// Update membership lastUpdate when user is added to a role
 			if _, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ?", workspace, user); err != nil {
 				return err
@@ -649,7 +659,7 @@ func (p *Provider) UserHasPermission(lookup rubix.Lookup, permissions ...app.Sco
 	return true, nil
 }
 
-func (p *Provider) SetMembershipType(workspace, user string, MembershipType rubix.MembershipType) error {
+func (p *Provider) SetMembershipType(workspace, user string, MembershipType rubix.MembershipType, options ...rubix.MembershipChangeOption) error {
 
 	switch MembershipType {
 	case rubix.MembershipTypeOwner, rubix.MembershipTypeMember, rubix.MembershipTypeSupport:
@@ -657,12 +667,30 @@ func (p *Provider) SetMembershipType(workspace, user string, MembershipType rubi
 		return errors.New("invalid user type")
 	}
 
-	_, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET type = ? WHERE workspace = ? AND user = ?", MembershipType, workspace, user)
+	_, fromType, found, err := p.currentMembership(workspace, user)
+	if err != nil || !found {
+		return err
+	}
+	if fromType == MembershipType {
+		return nil
+	}
+
+	res, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET type = ? WHERE workspace = ? AND user = ? AND type = ?", MembershipType, workspace, user, fromType)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		meta := rubix.ApplyMembershipChangeOptions(options...)
+		err = p.recordMembershipChange(rubix.MembershipChange{
+			Workspace: workspace, User: user, Kind: rubix.MembershipChangeType,
+			FromType: fromType, ToType: MembershipType, Actor: meta.Actor, Reason: meta.Reason,
+		})
+	}
 	p.update()
 	return err
 }
 
-func (p *Provider) SetMembershipState(workspace, user string, userState rubix.MembershipState) error {
+func (p *Provider) SetMembershipState(workspace, user string, userState rubix.MembershipState, options ...rubix.MembershipChangeOption) error {
 
 	switch userState {
 	case rubix.MembershipStatePending, rubix.MembershipStateActive, rubix.MembershipStateSuspended, rubix.MembershipStateArchived, rubix.MembershipStateRejected:
@@ -672,14 +700,14 @@ func (p *Provider) SetMembershipState(workspace, user string, userState rubix.Me
 		return errors.New("invalid user state")
 	}
 
-	_, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET state = ? WHERE workspace = ? AND user = ?", userState, workspace, user)
+	err := p.changeMembershipState(workspace, user, userState, rubix.ApplyMembershipChangeOptions(options...))
 	p.update()
 	return err
 }
 
-func (p *Provider) RemoveUserFromWorkspace(workspace, user string) error {
+func (p *Provider) RemoveUserFromWorkspace(workspace, user string, options ...rubix.MembershipChangeOption) error {
 
-	_, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET state = ? WHERE workspace = ? AND user = ?", rubix.MembershipStateRemoved, workspace, user)
+	err := p.changeMembershipState(workspace, user, rubix.MembershipStateRemoved, rubix.ApplyMembershipChangeOptions(options...))
 	p.update()
 	return err
 }
@@ -711,7 +739,7 @@ func (p *Provider) GetRole(workspace, role string) (*rubix.Role, error) {
 	})
 	g.Go(func() error {
 
-		rows, err := p.primaryConnection.Query("SELECT user FROM user_roles WHERE workspace = ? AND role = ?", workspace, role)
+		rows, err := p.primaryConnection.Query("SELECT user FROM user_roles WHERE workspace = ? AND role = ? AND (expires_at IS NULL OR expires_at > ?)", workspace, role, time.Now().UTC())
 		if err != nil {
 			return err
 		}
@@ -777,6 +805,24 @@ func (p *Provider) GetRole(workspace, role string) (*rubix.Role, error) {
 		}
 		return nil
 	})
+	g.Go(func() error {
+		graph, err := p.loadRoleGraph(workspace)
+		if err != nil {
+			return err
+		}
+		ret.ParentRoles = graph[role]
+		if len(ret.ParentRoles) == 0 {
+			return nil
+		}
+
+		model := &permissionModel{workspace: workspace, graph: graph}
+		model.roles, err = p.loadRoleDefinitions(workspace, graph.ancestry(role), nil)
+		if err != nil {
+			return err
+		}
+		ret.InheritedPermissions = model.inheritedPermissions(role)
+		return nil
+	})
 
 	return &ret, g.Wait()
 }
@@ -830,14 +876,23 @@ func (p *Provider) GetRoles(workspace string) ([]rubix.Role, error) {
 
 func (p *Provider) GetUserRoles(workspace, user string) ([]rubix.UserRole, error) {
 
-	roleIDs, err := p.GetUserRoleIDs(workspace, user)
+	rows, err := p.primaryConnection.Query("SELECT role, expires_at FROM user_roles WHERE workspace = ? AND user = ? AND (expires_at IS NULL OR expires_at > ?)", workspace, user, time.Now().UTC())
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var roles []rubix.UserRole
-	for _, roleID := range roleIDs {
-		roles = append(roles, rubix.UserRole{Workspace: workspace, User: user, Role: roleID})
+	for rows.Next() {
+		var role = rubix.UserRole{Workspace: workspace, User: user}
+		expiresAt := sql.NullString{}
+		if err = rows.Scan(&role.Role, &expiresAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid && expiresAt.String != "" {
+			role.ExpiresAt = timeFromString(expiresAt.String)
+		}
+		roles = append(roles, role)
 	}
 
 	return roles, nil
@@ -845,30 +900,25 @@ func (p *Provider) GetUserRoles(workspace, user string) ([]rubix.UserRole, error
 
 func (p *Provider) GetUserRoleIDs(workspace, user string) ([]string, error) {
 
-	rows, err := p.primaryConnection.Query("SELECT role FROM user_roles WHERE workspace = ? AND user = ?", workspace, user)
+	roles, err := p.GetUserRoles(workspace, user)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var roles []string
-	for rows.Next() {
-
-		var role = ""
-		err = rows.Scan(&role)
-		if err != nil {
-			return nil, err
-		}
 
-		roles = append(roles, role)
+	var roleIDs []string
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.Role)
 	}
 
-	return roles, nil
+	return roleIDs, nil
 }
 
 func (p *Provider) DeleteRole(workspace, role string) error {
 
 	_, err := p.primaryConnection.Exec("DELETE FROM roles  WHERE workspace = ? AND role = ?", workspace, role)
+	if err == nil {
+		_, err = p.primaryConnection.Exec("DELETE FROM role_parents WHERE workspace = ? AND (role = ? OR parent = ?)", workspace, role, role)
+	}
 	p.update()
 	return err
 }
@@ -901,6 +951,31 @@ func (p *Provider) MutateRole(workspace, role string, options ...rubix.MutateRol
 		opt(&payload)
 	}
 
+	if payload.Conditions != nil {
+		if err := payload.Conditions.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, parent := range payload.ParentsToAdd {
+		if err := p.roleExists(workspace, parent); err != nil {
+			return err
+		}
+	}
+	// Parent changes are checked as a whole before anything is written, so a cycle leaves the role untouched
+	if len(payload.ParentsToAdd) > 0 {
+		graph, err := p.loadRoleGraph(workspace)
+		if err != nil {
+			return err
+		}
+		graph[role] = slices.DeleteFunc(graph[role], func(parent string) bool { return slices.Contains(payload.ParentsToRem, parent) })
+		for _, parent := range payload.ParentsToAdd {
+			if parent == role || graph.createsCycle(role, parent) {
+				return rubix.ErrRoleInheritanceCycle
+			}
+			graph[role] = append(graph[role], parent)
+		}
+	}
+
 	g := errgroup.Group{}
 	g.Go(func() error {
 
@@ -954,15 +1029,14 @@ func (p *Provider) MutateRole(workspace, role string, options ...rubix.MutateRol
 	g.Go(func() error {
 
 		for _, user := range payload.UsersToAdd {
-			_, err := p.primaryConnection.Exec("INSERT INTO user_roles (workspace, user, role) VALUES (?, ?, ?)", workspace, user, role)
-
-			if p.isDuplicateConflict(err) {
-				// No change
-				continue
-			}
+			added, err := p.grantRole(workspace, user, role, payload.UserExpiry[user], payload.ShortenExpiry)
 			if err != nil {
 				return err
 			}
+			if !added {
+				// No change
+				continue
+			}
 			// Update membership lastUpdate for the affected user
 			if _, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ?", workspace, user); err != nil {
 				return err
@@ -1022,6 +1096,54 @@ func (p *Provider) MutateRole(workspace, role string, options ...rubix.MutateRol
 
 		return nil
 	})
+	g.Go(func() error {
+
+		for _, perm := range payload.PermsToDeny {
+			query := "INSERT INTO role_permissions (workspace, role, permission, allow) VALUES (?, ?, ?, 0)"
+			if p.SqlLite {
+				query += " ON CONFLICT(workspace, role, permission, resource) DO UPDATE SET allow = 0"
+			} else {
+				query += " ON DUPLICATE KEY UPDATE allow = 0"
+			}
+			if _, err := p.primaryConnection.Exec(query, workspace, role, perm); err != nil {
+				return err
+			}
+			if _, err := p.primaryConnection.Exec("UPDATE roles SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND role = ?", workspace, role); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	g.Go(func() error {
+
+		if len(payload.ParentsToAdd) == 0 && len(payload.ParentsToRem) == 0 {
+			return nil
+		}
+
+		tx, err := p.primaryConnection.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		for _, parent := range payload.ParentsToRem {
+			if _, err := tx.Exec("DELETE FROM role_parents WHERE workspace = ? AND role = ? AND parent = ?", workspace, role, parent); err != nil {
+				return err
+			}
+		}
+		for _, parent := range payload.ParentsToAdd {
+			if _, err := tx.Exec(p.insertIgnore("INSERT INTO role_parents (workspace, role, parent) VALUES (?, ?, ?)", "workspace, role, parent", "parent"), workspace, role, parent); err != nil {
+				return err
+			}
+		}
+
+		// Inherited permissions changed for this role
+		if _, err = tx.Exec("UPDATE roles SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND role = ?", workspace, role); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
 	g.Go(func() error {
 		for perm, option := range payload.PermOptionToAdd {
 			optionsStr, err := json.Marshal(option)
@@ -2103,7 +2225,7 @@ func (p *Provider) CreateWorkspaceUser(workspace string, user rubix.WorkspaceUse
 // callers wanting the SCIM/OIDC directory entry should use GetWorkspaceUser.
 func (p *Provider) GetUser(workspace, userID string) (*rubix.User, error) {
 	row := p.primaryConnection.QueryRow(
-		"SELECT u.user, u.name, u.email FROM users AS u "+
+		"SELECT u.user, u.name, u.email, "+userProfileColumns+" FROM users AS u "+
 			"INNER JOIN workspace_memberships AS m ON m.user = u.user "+
 			"WHERE m.workspace = ? AND m.user = ?",
 		workspace, userID,
@@ -2111,7 +2233,8 @@ func (p *Provider) GetUser(workspace, userID string) (*rubix.User, error) {
 	var user rubix.User
 	name := sql.NullString{}
 	email := sql.NullString{}
-	if err := row.Scan(&user.UserID, &name, &email); err != nil {
+	profile := profileScanner{}
+	if err := row.Scan(append([]any{&user.UserID, &name, &email}, profile.dest()...)...); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, rubix.ErrNoResultFound
 		}
@@ -2119,6 +2242,7 @@ func (p *Provider) GetUser(workspace, userID string) (*rubix.User, error) {
 	}
 	user.Name = name.String
 	user.Email = email.String
+	user.UserProfile = profile.profile()
 	return &user, nil
 }
 
@@ -2310,11 +2434,25 @@ func (p *Provider) MigrateOIDCUsersToWorkspaceUsers() error {
 }
 
 func (p *Provider) GetResolvedMembers(workspace string, filter rubix.MemberFilter) ([]rubix.ResolvedMember, error) {
+	var schema map[string]rubix.MemberAttribute
+	if len(filter.Attributes) > 0 || filter.IncludeAttributes {
+		var err error
+		if schema, err = p.memberAttributeSchema(workspace); err != nil {
+			return nil, err
+		}
+		if err = validateAttributeFilters(schema, filter.Attributes); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get all memberships (or filtered by user IDs)
 	members, err := p.GetWorkspaceMembers(workspace, filter.UserIDs...)
 	if err != nil {
 		return nil, err
 	}
+	if err = p.loadMemberAttributes(workspace, schema, members); err != nil {
+		return nil, err
+	}
 
 	// Partition into native and OIDC user IDs
 	var oidcIDs []string
@@ -2358,6 +2496,9 @@ func (p *Provider) GetResolvedMembers(workspace string, filter rubix.MemberFilte
 		}
 
 		// Apply filters
+		if len(filter.Attributes) > 0 && !rubix.MatchAttributes(m.Attributes, schema, filter.Attributes...) {
+			continue
+		}
 		if filter.Source != "" && filter.Source != rm.Source {
 			continue
 		}
@@ -2371,25 +2512,26 @@ func (p *Provider) GetResolvedMembers(workspace string, filter rubix.MemberFilte
 	return resolved, nil
 }
 
-func (p *Provider) ipGroupResolver(workspace string) rubix.IPGroupResolver {
-	return func(groupID string) []string {
-		if g, err := p.GetIPGroup(workspace, groupID); err == nil {
-			return g.Entries
-		}
-		return nil
+// conditionResolvers supplies the workspace IP and location groups referenced by conditions
+func (p *Provider) conditionResolvers(workspace string) ([]rubix.ConditionResolver, error) {
+	ipGroups, err := p.compiledIPGroups(workspace)
+	if err != nil {
+		return nil, err
 	}
+	return []rubix.ConditionResolver{ipGroups, p.locationGroupResolver(workspace)}, nil
 }
 
 // --- IP Groups ---
 func (p *Provider) GetIPGroup(workspace, groupID string) (*rubix.IPGroup, error) {
 	ret := &rubix.IPGroup{Workspace: workspace, ID: groupID}
 	row := p.primaryConnection.QueryRow(
-		"SELECT name, description, source, entries, externalUrl, jsonPath, lastSynced, entryCount FROM ip_groups WHERE workspace = ? AND ip_group = ?",
+		"SELECT name, description, source, entries, externalUrl, jsonPath, lastSynced, entryCount, syncError, collapseOverlaps FROM ip_groups WHERE workspace = ? AND ip_group = ?",
 		workspace, groupID,
 	)
 	entriesJSON := sql.NullString{}
 	lastSynced := sql.NullString{}
-	if err := row.Scan(&ret.Name, &ret.Description, &ret.Source, &entriesJSON, &ret.ExternalURL, &ret.JSONPath, &lastSynced, &ret.EntryCount); err != nil {
+	syncError := sql.NullString{}
+	if err := row.Scan(&ret.Name, &ret.Description, &ret.Source, &entriesJSON, &ret.ExternalURL, &ret.JSONPath, &lastSynced, &ret.EntryCount, &syncError, &ret.CollapseOverlaps); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, rubix.ErrNoResultFound
 		}
@@ -2399,13 +2541,23 @@ func (p *Provider) GetIPGroup(workspace, groupID string) (*rubix.IPGroup, error)
 		json.Unmarshal([]byte(entriesJSON.String), &ret.Entries)
 	}
 	ret.LastSynced = lastSynced.String
+	ret.SyncError = syncError.String
 	return ret, nil
 }
 
 func (p *Provider) GetIPGroups(workspace string) ([]rubix.IPGroup, error) {
+	return p.queryIPGroups("WHERE workspace = ? ORDER BY name ASC", workspace)
+}
+
+// GetExternalIPGroups returns the externally sourced IP groups across all workspaces, for syncing
+func (p *Provider) GetExternalIPGroups() ([]rubix.IPGroup, error) {
+	return p.queryIPGroups("WHERE source = ? ORDER BY workspace, ip_group", "external")
+}
+
+func (p *Provider) queryIPGroups(where string, args ...any) ([]rubix.IPGroup, error) {
 	rows, err := p.primaryConnection.Query(
-		"SELECT ip_group, name, description, source, entries, externalUrl, jsonPath, lastSynced, entryCount FROM ip_groups WHERE workspace = ? ORDER BY name ASC",
-		workspace,
+		"SELECT workspace, ip_group, name, description, source, entries, externalUrl, jsonPath, lastSynced, entryCount, syncError, collapseOverlaps FROM ip_groups "+where,
+		args...,
 	)
 	if err != nil {
 		return nil, err
@@ -2414,26 +2566,34 @@ func (p *Provider) GetIPGroups(workspace string) ([]rubix.IPGroup, error) {
 	var items []rubix.IPGroup
 	for rows.Next() {
 		var it rubix.IPGroup
-		it.Workspace = workspace
 		entriesJSON := sql.NullString{}
 		lastSynced := sql.NullString{}
-		if err := rows.Scan(&it.ID, &it.Name, &it.Description, &it.Source, &entriesJSON, &it.ExternalURL, &it.JSONPath, &lastSynced, &it.EntryCount); err != nil {
+		syncError := sql.NullString{}
+		if err := rows.Scan(&it.Workspace, &it.ID, &it.Name, &it.Description, &it.Source, &entriesJSON, &it.ExternalURL, &it.JSONPath, &lastSynced, &it.EntryCount, &syncError, &it.CollapseOverlaps); err != nil {
 			return nil, err
 		}
 		if entriesJSON.Valid {
 			json.Unmarshal([]byte(entriesJSON.String), &it.Entries)
 		}
 		it.LastSynced = lastSynced.String
+		it.SyncError = syncError.String
 		items = append(items, it)
 	}
 	return items, nil
 }
 
 func (p *Provider) CreateIPGroup(workspace string, group rubix.IPGroup) error {
-	entriesBytes, _ := json.Marshal(group.Entries)
-	_, err := p.primaryConnection.Exec(
-		"INSERT INTO ip_groups (workspace, ip_group, name, description, source, entries, externalUrl, jsonPath, entryCount) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		workspace, group.ID, group.Name, group.Description, group.Source, string(entriesBytes), group.ExternalURL, group.JSONPath, group.EntryCount,
+	entries, err := prepareIPEntries(group.Entries, group.CollapseOverlaps)
+	if err != nil {
+		return err
+	}
+	if err = p.checkIPGroupReferences(workspace, group.ID, entries); err != nil {
+		return err
+	}
+	entriesBytes, _ := json.Marshal(entries)
+	_, err = p.primaryConnection.Exec(
+		"INSERT INTO ip_groups (workspace, ip_group, name, description, source, entries, externalUrl, jsonPath, entryCount, collapseOverlaps) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		workspace, group.ID, group.Name, group.Description, group.Source, string(entriesBytes), group.ExternalURL, group.JSONPath, len(entries), group.CollapseOverlaps,
 	)
 	if p.isDuplicateConflict(err) {
 		return errors.New("IP group already exists")
@@ -2441,6 +2601,7 @@ func (p *Provider) CreateIPGroup(workspace string, group rubix.IPGroup) error {
 	if err != nil {
 		return err
 	}
+	p.invalidateIPGroups(workspace)
 	p.update()
 	return nil
 }
@@ -2454,6 +2615,30 @@ func (p *Provider) MutateIPGroup(workspace, groupID string, options ...rubix.Mut
 	for _, opt := range options {
 		opt(&payload)
 	}
+
+	// Entries are stored normalised, so they are re-prepared whenever they or the collapse setting change
+	if payload.Entries != nil || payload.CollapseOverlaps != nil {
+		current, err := p.GetIPGroup(workspace, groupID)
+		if err != nil {
+			return err
+		}
+		entries, collapse := current.Entries, current.CollapseOverlaps
+		if payload.Entries != nil {
+			entries = *payload.Entries
+		}
+		if payload.CollapseOverlaps != nil {
+			collapse = *payload.CollapseOverlaps
+		}
+		if entries, err = prepareIPEntries(entries, collapse); err != nil {
+			return err
+		}
+		if err = p.checkIPGroupReferences(workspace, groupID, entries); err != nil {
+			return err
+		}
+		count := len(entries)
+		payload.Entries, payload.EntryCount = &entries, &count
+	}
+
 	var fields []string
 	var vals []any
 	if payload.Title != nil {
@@ -2489,12 +2674,21 @@ func (p *Provider) MutateIPGroup(workspace, groupID string, options ...rubix.Mut
 		fields = append(fields, "entryCount = ?")
 		vals = append(vals, *payload.EntryCount)
 	}
+	if payload.SyncError != nil {
+		fields = append(fields, "syncError = ?")
+		vals = append(vals, *payload.SyncError)
+	}
+	if payload.CollapseOverlaps != nil {
+		fields = append(fields, "collapseOverlaps = ?")
+		vals = append(vals, *payload.CollapseOverlaps)
+	}
 	if len(fields) == 0 {
 		return nil
 	}
 	vals = append(vals, workspace, groupID)
 	q := fmt.Sprintf("UPDATE ip_groups SET %s WHERE workspace = ? AND ip_group = ?", strings.Join(fields, ", "))
 	res, err := p.primaryConnection.Exec(q, vals...)
+	p.invalidateIPGroups(workspace)
 	if err != nil {
 		return err
 	}
@@ -2504,11 +2698,32 @@ func (p *Provider) MutateIPGroup(workspace, groupID string, options ...rubix.Mut
 	return nil
 }
 
+// prepareIPEntries validates and normalises entries for storage, collapsing overlaps when enabled
+func prepareIPEntries(entries []string, collapse bool) ([]string, error) {
+	normalized, err := rubix.NormalizeIPEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	if collapse {
+		return rubix.CollapseIPEntries(normalized), nil
+	}
+	return normalized, nil
+}
+
 func (p *Provider) DeleteIPGroup(workspace, groupID string) error {
-	_, err := p.primaryConnection.Exec("DELETE FROM ip_groups WHERE workspace = ? AND ip_group = ?", workspace, groupID)
+	// Referencing groups would silently change what they match, so they must be updated first
+	referrers, err := p.ipGroupReferrers(workspace, groupID)
+	if err != nil {
+		return err
+	}
+	if len(referrers) > 0 {
+		return fmt.Errorf("%s: %w", strings.Join(referrers, ", "), rubix.ErrIPGroupInUse)
+	}
+	_, err = p.primaryConnection.Exec("DELETE FROM ip_groups WHERE workspace = ? AND ip_group = ?", workspace, groupID)
 	if err != nil {
 		return err
 	}
+	p.invalidateIPGroups(workspace)
 	p.update()
 	return nil
 }
@@ -0,0 +1,186 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+func (p *Provider) GetLocationGroup(workspace, groupID string) (*rubix.LocationGroup, error) {
+	ret := &rubix.LocationGroup{Workspace: workspace, ID: groupID}
+	row := p.primaryConnection.QueryRow(
+		"SELECT name, description, locations FROM location_groups WHERE workspace = ? AND location_group = ?",
+		workspace, groupID,
+	)
+	locationsJSON := sql.NullString{}
+	if err := row.Scan(&ret.Name, &ret.Description, &locationsJSON); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, rubix.ErrNoResultFound
+		}
+		return nil, err
+	}
+	if locationsJSON.Valid {
+		json.Unmarshal([]byte(locationsJSON.String), &ret.Locations)
+	}
+	return ret, nil
+}
+
+func (p *Provider) GetLocationGroups(workspace string) ([]rubix.LocationGroup, error) {
+	rows, err := p.primaryConnection.Query(
+		"SELECT location_group, name, description, locations FROM location_groups WHERE workspace = ? ORDER BY name ASC",
+		workspace,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []rubix.LocationGroup
+	for rows.Next() {
+		it := rubix.LocationGroup{Workspace: workspace}
+		locationsJSON := sql.NullString{}
+		if err := rows.Scan(&it.ID, &it.Name, &it.Description, &locationsJSON); err != nil {
+			return nil, err
+		}
+		if locationsJSON.Valid {
+			json.Unmarshal([]byte(locationsJSON.String), &it.Locations)
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+func (p *Provider) CreateLocationGroup(workspace string, group rubix.LocationGroup) error {
+	locations, err := p.validateLocations(workspace, group.Locations)
+	if err != nil {
+		return err
+	}
+	locationsBytes, _ := json.Marshal(locations)
+	_, err = p.primaryConnection.Exec(
+		"INSERT INTO location_groups (workspace, location_group, name, description, locations) VALUES (?, ?, ?, ?, ?)",
+		workspace, group.ID, group.Name, group.Description, string(locationsBytes),
+	)
+	if p.isDuplicateConflict(err) {
+		return errors.New("location group already exists")
+	}
+	if err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+func (p *Provider) MutateLocationGroup(workspace, groupID string, options ...rubix.MutateLocationGroupOption) error {
+	if len(options) == 0 {
+		return nil
+	}
+	payload := rubix.MutateLocationGroupPayload{}
+	for _, opt := range options {
+		opt(&payload)
+	}
+	var locations []string
+	if payload.Locations != nil {
+		var err error
+		if locations, err = p.validateLocations(workspace, *payload.Locations); err != nil {
+			return err
+		}
+	}
+	defer p.update()
+	var fields []string
+	var vals []any
+	if payload.Name != nil {
+		fields = append(fields, "name = ?")
+		vals = append(vals, *payload.Name)
+	}
+	if payload.Description != nil {
+		fields = append(fields, "description = ?")
+		vals = append(vals, *payload.Description)
+	}
+	if payload.Locations != nil {
+		fields = append(fields, "locations = ?")
+		locationsBytes, _ := json.Marshal(locations)
+		vals = append(vals, string(locationsBytes))
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	vals = append(vals, workspace, groupID)
+	q := fmt.Sprintf("UPDATE location_groups SET %s WHERE workspace = ? AND location_group = ?", strings.Join(fields, ", "))
+	res, err := p.primaryConnection.Exec(q, vals...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return rubix.ErrNoResultFound
+	}
+	return nil
+}
+
+func (p *Provider) DeleteLocationGroup(workspace, groupID string) error {
+	_, err := p.primaryConnection.Exec("DELETE FROM location_groups WHERE workspace = ? AND location_group = ?", workspace, groupID)
+	if err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+func normalizeLocations(locations []string) []string {
+	ret := make([]string, 0, len(locations))
+	for _, location := range locations {
+		if location = rubix.NormalizeLocation(location); location != "" {
+			ret = append(ret, location)
+		}
+	}
+	return ret
+}
+
+// validateLocations normalises the locations, each must be a country or subdivision code,
+// a built-in location group or a location group in the workspace
+func (p *Provider) validateLocations(workspace string, locations []string) ([]string, error) {
+	normalized := normalizeLocations(locations)
+	var groups map[string]bool
+	for _, location := range normalized {
+		if rubix.IsLocationCode(location) {
+			continue
+		}
+		if _, ok := rubix.BuiltinLocationGroups[location]; ok {
+			continue
+		}
+		if groups == nil {
+			all, err := p.GetLocationGroups(workspace)
+			if err != nil {
+				return nil, err
+			}
+			groups = make(map[string]bool, len(all))
+			for _, g := range all {
+				groups[rubix.NormalizeLocation(g.ID)] = true
+			}
+		}
+		if !groups[location] {
+			return nil, fmt.Errorf("invalid location %q, expected a country code, subdivision code or location group", location)
+		}
+	}
+	return normalized, nil
+}
+
+// locationGroupResolver loads every location group in the workspace once, on first use,
+// keyed case-insensitively to match normalised condition locations
+func (p *Provider) locationGroupResolver(workspace string) rubix.LocationGroupResolver {
+	var once sync.Once
+	groups := map[string][]string{}
+	return func(groupID string) []string {
+		once.Do(func() {
+			if all, err := p.GetLocationGroups(workspace); err == nil {
+				for _, g := range all {
+					groups[rubix.NormalizeLocation(g.ID)] = g.Locations
+				}
+			}
+		})
+		return groups[rubix.NormalizeLocation(groupID)]
+	}
+}
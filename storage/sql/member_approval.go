@@ -0,0 +1,226 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// ListPendingMembers returns the memberships awaiting approval, oldest first
+func (p *Provider) ListPendingMembers(workspace string) ([]rubix.PendingMember, error) {
+	ws, err := p.RetrieveWorkspace(workspace)
+	if err != nil {
+		return nil, err
+	}
+	if ws == nil {
+		return nil, rubix.ErrNoResultFound
+	}
+	members, err := p.GetWorkspaceMembers(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []rubix.PendingMember
+	for _, member := range members {
+		if member.State != rubix.MembershipStatePending {
+			continue
+		}
+		pending = append(pending, rubix.PendingMember{
+			Membership:   member,
+			Domain:       emailDomain(member.Email),
+			ApprovalMode: memberApprovalMode(ws, member.Email, nil),
+		})
+	}
+	slices.SortFunc(pending, func(a, b rubix.PendingMember) int { return a.Since.Compare(b.Since) })
+	return pending, nil
+}
+
+// ApproveMember activates a pending membership and assigns the default roles and teams for the member's email domain
+func (p *Provider) ApproveMember(workspace, user, decidedBy string) error {
+	if _, err := p.decideMember(workspace, user, decidedBy, rubix.MembershipStateActive, ""); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+func (p *Provider) RejectMember(workspace, user, decidedBy, reason string) error {
+	if _, err := p.decideMember(workspace, user, decidedBy, rubix.MembershipStateRejected, reason); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+// autoApproveMember approves a self-requested membership when the member's email domain is eligible
+// and the approval mode for the domain, or the workspace, is auto. Other members stay in the queue.
+func (p *Provider) autoApproveMember(workspace, user string) error {
+	ws, err := p.RetrieveWorkspace(workspace)
+	if err != nil || ws == nil {
+		return err
+	}
+	email := sql.NullString{}
+	if err = p.primaryConnection.QueryRow("SELECT email FROM users WHERE user = ?", user).Scan(&email); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if _, eligible := memberEligibility(ws, email.String, nil); !eligible {
+		return nil
+	}
+	if memberApprovalMode(ws, email.String, nil) != rubix.MemberApprovalModeAuto {
+		return nil
+	}
+
+	reason := "auto-approved by workspace approval mode"
+	if domain := emailDomain(email.String); ws.EmailDomainApproval[domain] == rubix.MemberApprovalModeAuto {
+		reason = "auto-approved for domain " + domain
+	}
+	if _, err = p.decideMember(workspace, user, "", rubix.MembershipStateActive, reason); errors.Is(err, rubix.ErrAlreadyDecided) {
+		return nil
+	}
+	return err
+}
+
+// decideMember moves a pending membership to the decided state, recording who decided and when.
+// Approval assigns the default roles and teams for the member's email domain in the same transaction.
+// The state change is conditional, so concurrent decisions cannot both succeed.
+func (p *Provider) decideMember(workspace, user, decidedBy string, state rubix.MembershipState, reason string) (*rubix.Membership, error) {
+	member, err := p.getWorkspaceMember(workspace, user)
+	if err != nil {
+		return nil, err
+	}
+	if member.State != rubix.MembershipStatePending {
+		return nil, rubix.ErrAlreadyDecided
+	}
+	var defaults *rubix.MemberApprovalDefaults
+	if state == rubix.MembershipStateActive {
+		if defaults, err = p.memberApprovalDefaultsFor(workspace, emailDomain(member.Email)); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := p.primaryConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec("UPDATE workspace_memberships SET state = ?, state_since = ?, decided_by = ?, decided_at = ?, decision_reason = ?, lastUpdate = ? WHERE workspace = ? AND user = ? AND state = ?",
+		state, now, decidedBy, now, reason, now, workspace, user, rubix.MembershipStatePending)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, rubix.ErrAlreadyDecided
+	}
+	if err = insertMembershipChange(tx, rubix.MembershipChange{
+		Workspace: workspace, User: user, Kind: rubix.MembershipChangeState,
+		FromState: rubix.MembershipStatePending, ToState: state, FromType: member.Type, ToType: member.Type,
+		Actor: decidedBy, Reason: reason, At: now,
+	}); err != nil {
+		return nil, err
+	}
+	if defaults != nil {
+		if err = p.applyMemberApprovalDefaults(tx, workspace, user, defaults); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	member.State, member.StateSince = state, now
+	member.DecidedBy, member.DecidedAt, member.DecisionReason = decidedBy, now, reason
+	return member, nil
+}
+
+func (p *Provider) applyMemberApprovalDefaults(tx *sql.Tx, workspace, user string, defaults *rubix.MemberApprovalDefaults) error {
+	for _, role := range defaults.Roles {
+		if _, err := tx.Exec(p.insertIgnore("INSERT INTO user_roles (workspace, user, role) VALUES (?, ?, ?)", "workspace, user, role", "role"), workspace, user, role); err != nil {
+			return err
+		}
+	}
+	for _, team := range defaults.Teams {
+		if _, err := tx.Exec(p.insertIgnore("INSERT INTO user_teams (workspace, user, team, level) VALUES (?, ?, ?, ?)", "workspace, user, team", "team"), workspace, user, team, rubix.TeamLevelMember); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memberApprovalDefaultsFor returns the defaults for the domain, falling back to the workspace defaults
+func (p *Provider) memberApprovalDefaultsFor(workspace, domain string) (*rubix.MemberApprovalDefaults, error) {
+	all, err := p.GetMemberApprovalDefaults(workspace)
+	if err != nil {
+		return nil, err
+	}
+	var fallback *rubix.MemberApprovalDefaults
+	for i := range all {
+		switch all[i].Domain {
+		case domain:
+			return &all[i], nil
+		case "":
+			fallback = &all[i]
+		}
+	}
+	return fallback, nil
+}
+
+func (p *Provider) GetMemberApprovalDefaults(workspace string) ([]rubix.MemberApprovalDefaults, error) {
+	rows, err := p.primaryConnection.Query("SELECT domain, roles, teams FROM member_approval_defaults WHERE workspace = ? ORDER BY domain", workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []rubix.MemberApprovalDefaults
+	for rows.Next() {
+		it := rubix.MemberApprovalDefaults{Workspace: workspace}
+		roles := sql.NullString{}
+		teams := sql.NullString{}
+		if err := rows.Scan(&it.Domain, &roles, &teams); err != nil {
+			return nil, err
+		}
+		if roles.Valid {
+			json.Unmarshal([]byte(roles.String), &it.Roles)
+		}
+		if teams.Valid {
+			json.Unmarshal([]byte(teams.String), &it.Teams)
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// SetMemberApprovalDefaults sets the roles and teams given on approval to members of the domain,
+// an empty domain sets the workspace defaults. No roles or teams removes the defaults.
+func (p *Provider) SetMemberApprovalDefaults(workspace, domain string, roles, teams []string) error {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	tx, err := p.primaryConnection.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.Exec("DELETE FROM member_approval_defaults WHERE workspace = ? AND domain = ?", workspace, domain); err != nil {
+		return err
+	}
+	if len(roles) > 0 || len(teams) > 0 {
+		rolesBytes, _ := json.Marshal(roles)
+		teamsBytes, _ := json.Marshal(teams)
+		if _, err = tx.Exec("INSERT INTO member_approval_defaults (workspace, domain, roles, teams) VALUES (?, ?, ?, ?)",
+			workspace, domain, string(rolesBytes), string(teamsBytes)); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
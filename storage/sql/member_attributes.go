@@ -0,0 +1,237 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// SetMemberAttributeDefinition creates or updates an attribute in the workspace schema.
+// Changing the type is rejected when stored values are not valid for the new type.
+func (p *Provider) SetMemberAttributeDefinition(workspace string, attribute rubix.MemberAttribute) error {
+	attribute.Workspace = workspace
+	if err := attribute.Validate(); err != nil {
+		return err
+	}
+
+	values, err := p.primaryConnection.Query("SELECT value FROM member_attributes WHERE workspace = ? AND attr_key = ?", workspace, attribute.Key)
+	if err != nil {
+		return err
+	}
+	var invalid []string
+	for values.Next() {
+		var raw string
+		if err := values.Scan(&raw); err != nil {
+			values.Close()
+			return err
+		}
+		if _, err := attribute.Parse(raw); err != nil {
+			invalid = append(invalid, err.Error())
+		}
+	}
+	values.Close()
+	if len(invalid) > 0 {
+		return errors.New("existing values are not valid: " + strings.Join(invalid, "; "))
+	}
+
+	optionsBytes, _ := json.Marshal(attribute.Options)
+	if _, err = p.primaryConnection.Exec("DELETE FROM member_attribute_schema WHERE workspace = ? AND attr_key = ?", workspace, attribute.Key); err != nil {
+		return err
+	}
+	if _, err = p.primaryConnection.Exec("INSERT INTO member_attribute_schema (workspace, attr_key, name, description, type, options) VALUES (?, ?, ?, ?, ?, ?)",
+		workspace, attribute.Key, attribute.Name, attribute.Description, attribute.Type, string(optionsBytes)); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+func (p *Provider) GetMemberAttributeDefinitions(workspace string) ([]rubix.MemberAttribute, error) {
+	rows, err := p.primaryConnection.Query("SELECT attr_key, name, description, type, options FROM member_attribute_schema WHERE workspace = ? ORDER BY attr_key", workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attributes []rubix.MemberAttribute
+	for rows.Next() {
+		attribute := rubix.MemberAttribute{Workspace: workspace}
+		options := sql.NullString{}
+		if err := rows.Scan(&attribute.Key, &attribute.Name, &attribute.Description, &attribute.Type, &options); err != nil {
+			return nil, err
+		}
+		if options.Valid {
+			json.Unmarshal([]byte(options.String), &attribute.Options)
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, rows.Err()
+}
+
+// DeleteMemberAttributeDefinition removes the attribute from the schema along with every member's value
+func (p *Provider) DeleteMemberAttributeDefinition(workspace, key string) error {
+	if _, err := p.primaryConnection.Exec("DELETE FROM member_attributes WHERE workspace = ? AND attr_key = ?", workspace, key); err != nil {
+		return err
+	}
+	if _, err := p.primaryConnection.Exec("DELETE FROM member_attribute_schema WHERE workspace = ? AND attr_key = ?", workspace, key); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+// SetMemberAttributes validates the values against the workspace schema and stores them,
+// a nil value removes the attribute from the member. Other attributes are left unchanged.
+func (p *Provider) SetMemberAttributes(workspace, user string, values map[string]any) error {
+	schema, err := p.memberAttributeSchema(workspace)
+	if err != nil {
+		return err
+	}
+
+	stored := map[string]*string{}
+	var invalid []string
+	for key, value := range values {
+		if value == nil {
+			stored[key] = nil
+			continue
+		}
+		attribute, ok := schema[key]
+		if !ok {
+			invalid = append(invalid, "unknown attribute "+key)
+			continue
+		}
+		formatted, err := attribute.Format(value)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		stored[key] = &formatted
+	}
+	if len(invalid) > 0 {
+		return errors.New(strings.Join(invalid, "; "))
+	}
+
+	for key, value := range stored {
+		if _, err := p.primaryConnection.Exec("DELETE FROM member_attributes WHERE workspace = ? AND user = ? AND attr_key = ?", workspace, user, key); err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if _, err := p.primaryConnection.Exec("INSERT INTO member_attributes (workspace, user, attr_key, value) VALUES (?, ?, ?, ?)", workspace, user, key, *value); err != nil {
+			return err
+		}
+	}
+	if _, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ?", workspace, user); err != nil {
+		return err
+	}
+	p.update()
+	return nil
+}
+
+func (p *Provider) memberAttributeSchema(workspace string) (map[string]rubix.MemberAttribute, error) {
+	attributes, err := p.GetMemberAttributeDefinitions(workspace)
+	if err != nil {
+		return nil, err
+	}
+	schema := make(map[string]rubix.MemberAttribute, len(attributes))
+	for _, attribute := range attributes {
+		schema[attribute.Key] = attribute
+	}
+	return schema, nil
+}
+
+// GetWorkspaceMembersWithAttributes returns the members as GetWorkspaceMembers does, with their typed attributes
+func (p *Provider) GetWorkspaceMembersWithAttributes(workspace string, userIDs ...string) ([]rubix.Membership, error) {
+	members, err := p.GetWorkspaceMembers(workspace, userIDs...)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := p.memberAttributeSchema(workspace)
+	if err != nil {
+		return nil, err
+	}
+	return members, p.loadMemberAttributes(workspace, schema, members)
+}
+
+// memberAttributeBatchSize bounds the user IDs in a single attribute query
+const memberAttributeBatchSize = 500
+
+// loadMemberAttributes fills the typed attributes of the members, values no longer in the schema are skipped
+func (p *Provider) loadMemberAttributes(workspace string, schema map[string]rubix.MemberAttribute, members []rubix.Membership) error {
+	if len(members) == 0 || len(schema) == 0 {
+		return nil
+	}
+
+	index := make(map[string]int, len(members))
+	for i, member := range members {
+		index[member.UserID] = i
+	}
+	for start := 0; start < len(members); start += memberAttributeBatchSize {
+		batch := members[start:min(start+memberAttributeBatchSize, len(members))]
+		args := []any{workspace}
+		for _, member := range batch {
+			args = append(args, member.UserID)
+		}
+		rows, err := p.primaryConnection.Query("SELECT user, attr_key, value FROM member_attributes WHERE workspace = ? AND user IN (?"+strings.Repeat(",?", len(batch)-1)+")", args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var user, key, raw string
+			if err := rows.Scan(&user, &key, &raw); err != nil {
+				rows.Close()
+				return err
+			}
+			i, ok := index[user]
+			attribute, known := schema[key]
+			if !ok || !known {
+				continue
+			}
+			value, err := attribute.Parse(raw)
+			if err != nil {
+				continue
+			}
+			if members[i].Attributes == nil {
+				members[i].Attributes = map[string]any{}
+			}
+			members[i].Attributes[key] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAttributeFilters checks the filters name attributes in the schema with values of the attribute's type
+func validateAttributeFilters(schema map[string]rubix.MemberAttribute, filters []rubix.AttributeFilter) error {
+	var invalid []string
+	for _, filter := range filters {
+		if err := filter.Validate(); err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		attribute, ok := schema[filter.Key]
+		if !ok {
+			invalid = append(invalid, "unknown attribute "+filter.Key)
+			continue
+		}
+		for _, value := range filter.Values {
+			if _, err := attribute.Parse(value); err != nil {
+				invalid = append(invalid, err.Error())
+			}
+		}
+		if attribute.Type == rubix.AttributeTypeBool && (filter.Operator == rubix.AttributeGreaterThan || filter.Operator == rubix.AttributeLessThan) {
+			invalid = append(invalid, filter.Key+": "+string(filter.Operator)+" does not apply to bool attributes")
+		}
+	}
+	if len(invalid) > 0 {
+		return errors.New(strings.Join(invalid, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// DefaultMemberImportBatchSize is used when ImportMembers is given no batch size
+const DefaultMemberImportBatchSize = 100
+
+// ImportMembers validates every row, then creates the users, active memberships, role assignments and
+// team memberships in batches. Nothing is imported if any row is invalid. Each batch is applied in a
+// transaction, so a failing row rolls back its batch while other batches are still applied.
+// Existing users keep their name and email, existing memberships take the type and partner only when the row
+// supplies them, and keep their state, with rows for memberships that are not active reported as inactive.
+func (p *Provider) ImportMembers(workspace string, rows []rubix.MemberImportRow, batchSize int) (*rubix.MemberImportReport, error) {
+	ws, err := p.RetrieveWorkspace(workspace)
+	if err != nil {
+		return nil, err
+	}
+	if ws == nil {
+		return nil, rubix.ErrNoResultFound
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultMemberImportBatchSize
+	}
+
+	report, err := p.validateMemberImport(workspace, rows)
+	if err != nil || report.Count(rubix.MemberImportStatusInvalid) > 0 {
+		return report, err
+	}
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := min(start+batchSize, len(rows))
+		outcomes, rowErr := p.importMemberBatch(workspace, rows[start:end])
+		for i := start; i < end; i++ {
+			if rowErr != nil {
+				report.Results[i].Status = rubix.MemberImportStatusFailed
+				report.Results[i].Errors = []string{rowErr.Error()}
+			} else {
+				report.Results[i].Status, report.Results[i].Reason = outcomes[i-start].Status, outcomes[i-start].Reason
+			}
+		}
+	}
+
+	if report.Imported() > 0 {
+		p.update()
+	}
+	return report, nil
+}
+
+// validateMemberImport checks every row, marking valid rows as skipped until they are imported
+func (p *Provider) validateMemberImport(workspace string, rows []rubix.MemberImportRow) (*rubix.MemberImportReport, error) {
+	roles, err := p.GetRoles(workspace)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := p.GetTeams(workspace)
+	if err != nil {
+		return nil, err
+	}
+	knownRoles := map[string]bool{}
+	for _, role := range roles {
+		knownRoles[role.ID] = true
+	}
+	knownTeams := map[string]bool{}
+	for _, team := range teams {
+		knownTeams[team.ID] = true
+	}
+
+	report := &rubix.MemberImportReport{Workspace: workspace}
+	seen := map[string]int{}
+	for i, row := range rows {
+		result := rubix.MemberImportResult{Row: i + 1, UserID: row.UserID, Status: rubix.MemberImportStatusSkipped}
+		if row.UserID == "" {
+			result.Errors = append(result.Errors, "user ID is required")
+		} else if first, dup := seen[row.UserID]; dup {
+			result.Errors = append(result.Errors, "user ID duplicates row "+strconv.Itoa(first))
+		} else {
+			seen[row.UserID] = i + 1
+		}
+		if row.Email != "" {
+			if addr, err := mail.ParseAddress(row.Email); err != nil || addr.Address != row.Email {
+				result.Errors = append(result.Errors, "invalid email "+row.Email)
+			}
+		}
+		switch row.Type {
+		case "", rubix.MembershipTypeOwner, rubix.MembershipTypeMember, rubix.MembershipTypeSupport:
+		default:
+			result.Errors = append(result.Errors, "invalid membership type "+string(row.Type))
+		}
+		for _, role := range row.Roles {
+			if !knownRoles[role] {
+				result.Errors = append(result.Errors, "unknown role "+role)
+			}
+		}
+		for _, team := range row.Teams {
+			if !knownTeams[team] {
+				result.Errors = append(result.Errors, "unknown team "+team)
+			}
+		}
+		if len(result.Errors) > 0 {
+			result.Status = rubix.MemberImportStatusInvalid
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// importMemberBatch applies the rows in a transaction, returning the status and reason of each
+func (p *Provider) importMemberBatch(workspace string, rows []rubix.MemberImportRow) ([]rubix.MemberImportResult, error) {
+	tx, err := p.primaryConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	outcomes := make([]rubix.MemberImportResult, len(rows))
+	for i, row := range rows {
+		if outcomes[i].Status, outcomes[i].Reason, err = p.importMember(tx, workspace, row); err != nil {
+			return nil, errors.New(row.UserID + ": " + err.Error())
+		}
+	}
+	return outcomes, tx.Commit()
+}
+
+// importMember applies a row. Existing memberships keep their state, so rows for members that are
+// not active are reported as inactive with the reason, rather than as granting access.
+func (p *Provider) importMember(tx *sql.Tx, workspace string, row rubix.MemberImportRow) (rubix.MemberImportStatus, string, error) {
+	// New memberships default to member, existing ones keep their type unless the row supplies one
+	newType := row.Type
+	if newType == "" {
+		newType = rubix.MembershipTypeMember
+	}
+	now := time.Now().UTC()
+
+	if _, err := tx.Exec(p.insertIgnore("INSERT INTO users (user, name, email) VALUES (?, ?, ?)", "user", "user"), row.UserID, row.Name, row.Email); err != nil {
+		return "", "", err
+	}
+
+	status, reason := rubix.MemberImportStatusUpdated, ""
+	var state rubix.MembershipState
+	var membershipType rubix.MembershipType
+	err := tx.QueryRow("SELECT state, type FROM workspace_memberships WHERE workspace = ? AND user = ?", workspace, row.UserID).Scan(&state, &membershipType)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		status = rubix.MemberImportStatusCreated
+		if _, err = tx.Exec("INSERT INTO workspace_memberships (user, workspace, type, since, state_since, state, partner_id, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			row.UserID, workspace, newType, now, now, rubix.MembershipStateActive, row.PartnerID, rubix.MembershipSourceImport); err != nil {
+			return "", "", err
+		}
+		err = insertMembershipChange(tx, rubix.MembershipChange{
+			Workspace: workspace, User: row.UserID, Kind: rubix.MembershipChangeJoined,
+			ToState: rubix.MembershipStateActive, ToType: newType, Reason: string(rubix.MembershipSourceImport), At: now,
+		})
+	case err != nil:
+		return "", "", err
+	case state == rubix.MembershipStateRemoved:
+		status = rubix.MemberImportStatusCreated
+		if _, err = tx.Exec("UPDATE workspace_memberships SET state = ?, state_since = ?, type = ?, partner_id = ?, source = ?, "+membershipRejoinReset+" WHERE workspace = ? AND user = ?",
+			rubix.MembershipStateActive, now, newType, row.PartnerID, rubix.MembershipSourceImport, workspace, row.UserID); err != nil {
+			return "", "", err
+		}
+		err = insertMembershipChange(tx, rubix.MembershipChange{
+			Workspace: workspace, User: row.UserID, Kind: rubix.MembershipChangeJoined,
+			FromState: state, ToState: rubix.MembershipStateActive, FromType: membershipType, ToType: newType,
+			Reason: string(rubix.MembershipSourceImport), At: now,
+		})
+	default:
+		if state != rubix.MembershipStateActive {
+			status, reason = rubix.MemberImportStatusInactive, "membership is "+strings.ToLower(state.Display())
+		}
+		// Only columns the row supplies are updated, so a partial import cannot demote owners or drop partners
+		var fields []string
+		var vals []any
+		if row.Type != "" && row.Type != membershipType {
+			fields = append(fields, "type = ?")
+			vals = append(vals, row.Type)
+		}
+		if row.PartnerID != "" {
+			fields = append(fields, "partner_id = ?")
+			vals = append(vals, row.PartnerID)
+		}
+		if len(fields) > 0 {
+			if _, err = tx.Exec("UPDATE workspace_memberships SET "+strings.Join(fields, ", ")+" WHERE workspace = ? AND user = ?",
+				append(vals, workspace, row.UserID)...); err != nil {
+				return "", "", err
+			}
+		}
+		if row.Type != "" && membershipType != row.Type {
+			err = insertMembershipChange(tx, rubix.MembershipChange{
+				Workspace: workspace, User: row.UserID, Kind: rubix.MembershipChangeType,
+				FromState: state, ToState: state, FromType: membershipType, ToType: row.Type,
+				Reason: string(rubix.MembershipSourceImport), At: now,
+			})
+		}
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, role := range row.Roles {
+		if _, err := tx.Exec(p.insertIgnore("INSERT INTO user_roles (workspace, user, role) VALUES (?, ?, ?)", "workspace, user, role", "role"), workspace, row.UserID, role); err != nil {
+			return "", "", err
+		}
+	}
+	for _, team := range row.Teams {
+		if _, err := tx.Exec(p.insertIgnore("INSERT INTO user_teams (workspace, user, team, level) VALUES (?, ?, ?, ?)", "workspace, user, team", "team"), workspace, row.UserID, team, rubix.TeamLevelMember); err != nil {
+			return "", "", err
+		}
+	}
+	if _, err := tx.Exec("UPDATE workspace_memberships SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ?", workspace, row.UserID); err != nil {
+		return "", "", err
+	}
+	return status, reason, nil
+}
+
+// insertIgnore makes the insert leave existing rows untouched, conflict lists the key columns
+// and column is rewritten to itself on MySQL
+func (p *Provider) insertIgnore(query, conflict, column string) string {
+	if p.SqlLite {
+		return query + " ON CONFLICT(" + conflict + ") DO NOTHING"
+	}
+	return query + " ON DUPLICATE KEY UPDATE `" + column + "` = `" + column + "`"
+}
@@ -0,0 +1,154 @@
+package sql
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// SetMembershipExpiry sets when the membership ends and what happens then, a zero time removes the expiry
+func (p *Provider) SetMembershipExpiry(workspace, user string, expiresAt time.Time, action rubix.MembershipExpiryAction, options ...rubix.MembershipChangeOption) error {
+	switch action {
+	case "":
+		action = rubix.MembershipExpirySuspend
+	case rubix.MembershipExpirySuspend, rubix.MembershipExpiryRemove:
+	default:
+		return errors.New("invalid membership expiry action")
+	}
+	if expiresAt.IsZero() {
+		action = ""
+	}
+
+	member, err := p.getWorkspaceMember(workspace, user)
+	if err != nil {
+		return err
+	}
+
+	err = p.updateMembershipExpiry(*member, expiresAt, action, rubix.ApplyMembershipChangeOptions(options...))
+	p.update()
+	return err
+}
+
+// ExtendMembership pushes an expiring membership back by the duration, counting from now if it has
+// already expired, and returns the new expiry. Memberships already suspended or removed by the sweeper
+// must be restored separately.
+func (p *Provider) ExtendMembership(workspace, user string, by time.Duration, options ...rubix.MembershipChangeOption) (time.Time, error) {
+	if by <= 0 {
+		return time.Time{}, errors.New("extension must be positive")
+	}
+	member, err := p.getWorkspaceMember(workspace, user)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if member.ExpiresAt.IsZero() {
+		return time.Time{}, errors.New("membership does not expire")
+	}
+
+	from := member.ExpiresAt
+	if now := time.Now().UTC(); from.Before(now) {
+		from = now
+	}
+	expiresAt := from.Add(by)
+	if err = p.updateMembershipExpiry(*member, expiresAt, member.ExpiryAction, rubix.ApplyMembershipChangeOptions(options...)); err != nil {
+		return time.Time{}, err
+	}
+	p.update()
+	return expiresAt, nil
+}
+
+func (p *Provider) updateMembershipExpiry(member rubix.Membership, expiresAt time.Time, action rubix.MembershipExpiryAction, meta rubix.MembershipChangeMeta) error {
+	if _, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET expires_at = ?, expiry_action = ?, lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ?",
+		nullTime(expiresAt), action, member.Workspace, member.UserID); err != nil {
+		return err
+	}
+	if member.ExpiresAt.Equal(expiresAt) && member.ExpiryAction == action {
+		return nil
+	}
+	return p.recordMembershipChange(rubix.MembershipChange{
+		Workspace: member.Workspace, User: member.UserID, Kind: rubix.MembershipChangeExpiry,
+		FromState: member.State, ToState: member.State, FromType: member.Type, ToType: member.Type,
+		FromExpiry: member.ExpiresAt, ToExpiry: expiresAt, Actor: meta.Actor, Reason: meta.Reason,
+	})
+}
+
+// GetExpiringMembers returns members whose membership expires within the duration, soonest first,
+// for sending notifications before access is lost
+func (p *Provider) GetExpiringMembers(workspace string, within time.Duration) ([]rubix.Membership, error) {
+	members, err := p.GetWorkspaceMembers(workspace)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	var expiring []rubix.Membership
+	for _, member := range members {
+		if member.ExpiresAt.IsZero() || member.Expired(now) || member.ExpiresAt.After(now.Add(within)) {
+			continue
+		}
+		if member.State == member.ExpiryAction.State() {
+			continue
+		}
+		expiring = append(expiring, member)
+	}
+	slices.SortFunc(expiring, func(a, b rubix.Membership) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+	return expiring, nil
+}
+
+// SweepExpiredMemberships applies the expiry action to every expired membership across all
+// workspaces, returning the memberships changed with their new state. AfterUpdate handlers are
+// notified when anything changed.
+func (p *Provider) SweepExpiredMemberships() ([]rubix.Membership, error) {
+	now := time.Now().UTC()
+	rows, err := p.primaryConnection.Query("SELECT workspace, user, type, state, expires_at, expiry_action FROM workspace_memberships WHERE expires_at IS NOT NULL AND expires_at <= ? AND state != ?",
+		now, rubix.MembershipStateRemoved)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []rubix.Membership
+	for rows.Next() {
+		var member rubix.Membership
+		var expiresAt string
+		if err := rows.Scan(&member.Workspace, &member.UserID, &member.Type, &member.State, &expiresAt, &member.ExpiryAction); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		member.ExpiresAt = timeFromString(expiresAt)
+		if member.State != member.ExpiryAction.State() {
+			expired = append(expired, member)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var changed []rubix.Membership
+	for _, member := range expired {
+		state := member.ExpiryAction.State()
+		// Re-check the state and expiry so a membership changed or extended since the select is kept
+		res, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET state = ?, state_since = ?, lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ? AND state = ? AND expires_at IS NOT NULL AND expires_at <= ?",
+			state, now, member.Workspace, member.UserID, member.State, now)
+		if err != nil {
+			return changed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := p.recordMembershipChange(rubix.MembershipChange{
+			Workspace: member.Workspace, User: member.UserID, Kind: rubix.MembershipChangeState,
+			FromState: member.State, ToState: state, FromType: member.Type, ToType: member.Type,
+			Reason: "membership expired", At: now,
+		}); err != nil {
+			return changed, err
+		}
+		member.State, member.StateSince = state, now
+		changed = append(changed, member)
+	}
+
+	if len(changed) > 0 {
+		p.update()
+	}
+	return changed, nil
+}
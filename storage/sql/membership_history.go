@@ -0,0 +1,104 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// GetMembershipHistory returns the member's state and type changes, oldest first
+func (p *Provider) GetMembershipHistory(workspace, user string) (rubix.MembershipTimeline, error) {
+	rows, err := p.primaryConnection.Query("SELECT kind, from_state, to_state, from_type, to_type, from_expiry, to_expiry, actor, reason, changed_at FROM workspace_membership_history WHERE workspace = ? AND user = ? ORDER BY seq",
+		workspace, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var timeline rubix.MembershipTimeline
+	for rows.Next() {
+		change := rubix.MembershipChange{Workspace: workspace, User: user}
+		changedAt := sql.NullString{}
+		fromExpiry := sql.NullString{}
+		toExpiry := sql.NullString{}
+		if err := rows.Scan(&change.Kind, &change.FromState, &change.ToState, &change.FromType, &change.ToType, &fromExpiry, &toExpiry, &change.Actor, &change.Reason, &changedAt); err != nil {
+			return nil, err
+		}
+		if fromExpiry.Valid {
+			change.FromExpiry = timeFromString(fromExpiry.String)
+		}
+		if toExpiry.Valid {
+			change.ToExpiry = timeFromString(toExpiry.String)
+		}
+		change.At = timeFromString(changedAt.String)
+		timeline = append(timeline, change)
+	}
+	return timeline, rows.Err()
+}
+
+// recordMembershipChange inserts the change, retrying when a concurrent insert took the same sequence number
+func (p *Provider) recordMembershipChange(change rubix.MembershipChange) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = insertMembershipChange(p.primaryConnection, change); !p.isDuplicateConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// execer is satisfied by both the connection and a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertMembershipChange appends the change with the next sequence number in the workspace, assigned by the
+// insert itself so it is ordered within the caller's transaction. The unique (workspace, seq) index rejects
+// a concurrent insert that read the same maximum.
+func insertMembershipChange(db execer, change rubix.MembershipChange) error {
+	if change.At.IsZero() {
+		change.At = time.Now().UTC()
+	}
+	_, err := db.Exec("INSERT INTO workspace_membership_history (workspace, user, kind, from_state, to_state, from_type, to_type, from_expiry, to_expiry, actor, reason, changed_at, seq) "+
+		"SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(MAX(seq), 0) + 1 FROM workspace_membership_history WHERE workspace = ?",
+		change.Workspace, change.User, change.Kind, change.FromState, change.ToState, change.FromType, change.ToType,
+		nullTime(change.FromExpiry), nullTime(change.ToExpiry), change.Actor, change.Reason, change.At, change.Workspace)
+	return err
+}
+
+// currentMembership reads the membership state and type, including removed memberships
+func (p *Provider) currentMembership(workspace, user string) (rubix.MembershipState, rubix.MembershipType, bool, error) {
+	var state rubix.MembershipState
+	var membershipType rubix.MembershipType
+	err := p.primaryConnection.QueryRow("SELECT state, type FROM workspace_memberships WHERE workspace = ? AND user = ?", workspace, user).Scan(&state, &membershipType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, membershipType, false, nil
+	}
+	return state, membershipType, err == nil, err
+}
+
+// changeMembershipState moves the membership to the state and records the transition.
+// The update is conditional on the state read, so concurrent changes are each recorded once.
+func (p *Provider) changeMembershipState(workspace, user string, state rubix.MembershipState, meta rubix.MembershipChangeMeta) error {
+	fromState, membershipType, found, err := p.currentMembership(workspace, user)
+	if err != nil || !found || fromState == state {
+		return err
+	}
+
+	now := time.Now().UTC()
+	res, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET state = ?, state_since = ? WHERE workspace = ? AND user = ? AND state = ?",
+		state, now, workspace, user, fromState)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	return p.recordMembershipChange(rubix.MembershipChange{
+		Workspace: workspace, User: user, Kind: rubix.MembershipChangeState,
+		FromState: fromState, ToState: state, FromType: membershipType, ToType: membershipType,
+		Actor: meta.Actor, Reason: meta.Reason, At: now,
+	})
+}
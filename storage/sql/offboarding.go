@@ -0,0 +1,201 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// OffboardUser removes the user from the workspace along with their roles, teams, BPO management,
+// statuses, user-scoped auth data, activation state, custom attributes and OIDC directory entry, so nothing is restored
+// if they rejoin. Everything happens in one transaction, and the report is archived for later review.
+func (p *Provider) OffboardUser(workspace, user string, options ...rubix.MembershipChangeOption) (*rubix.OffboardingReport, error) {
+	meta := rubix.ApplyMembershipChangeOptions(options...)
+	report := &rubix.OffboardingReport{Workspace: workspace, User: user, Actor: meta.Actor, Reason: meta.Reason, At: time.Now().UTC()}
+
+	tx, err := p.primaryConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = offboardMembership(tx, report); err != nil {
+		return nil, err
+	}
+	if err = offboardAssignments(tx, report); err != nil {
+		return nil, err
+	}
+
+	reportBytes, _ := json.Marshal(report)
+	if _, err = tx.Exec("INSERT INTO member_offboarding (workspace, user, offboarded_at, actor, reason, report) VALUES (?, ?, ?, ?, ?, ?)",
+		workspace, user, report.At, report.Actor, report.Reason, string(reportBytes)); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	p.update()
+	return report, nil
+}
+
+// GetOffboardingReports returns the archived offboarding reports for the user, newest first
+func (p *Provider) GetOffboardingReports(workspace, user string) ([]rubix.OffboardingReport, error) {
+	rows, err := p.primaryConnection.Query("SELECT report FROM member_offboarding WHERE workspace = ? AND user = ? ORDER BY offboarded_at DESC", workspace, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []rubix.OffboardingReport
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var report rubix.OffboardingReport
+		if err := json.Unmarshal([]byte(raw), &report); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func offboardMembership(tx *sql.Tx, report *rubix.OffboardingReport) error {
+	var membershipType rubix.MembershipType
+	err := tx.QueryRow("SELECT state, type FROM workspace_memberships WHERE workspace = ? AND user = ?", report.Workspace, report.User).
+		Scan(&report.PreviousState, &membershipType)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && report.PreviousState == rubix.MembershipStateRemoved) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE workspace_memberships SET state = ?, state_since = ? WHERE workspace = ? AND user = ?",
+		rubix.MembershipStateRemoved, report.At, report.Workspace, report.User); err != nil {
+		return err
+	}
+	report.MembershipRemoved = true
+	return insertMembershipChange(tx, rubix.MembershipChange{
+		Workspace: report.Workspace, User: report.User, Kind: rubix.MembershipChangeState,
+		FromState: report.PreviousState, ToState: rubix.MembershipStateRemoved, FromType: membershipType, ToType: membershipType,
+		Actor: report.Actor, Reason: report.Reason, At: report.At,
+	})
+}
+
+func offboardAssignments(tx *sql.Tx, report *rubix.OffboardingReport) error {
+	ws, user := report.Workspace, report.User
+
+	err := collectRows(tx, func(rows *sql.Rows) error {
+		ur := rubix.UserRole{Workspace: ws, User: user}
+		expiresAt := sql.NullString{}
+		if err := rows.Scan(&ur.Role, &expiresAt); err != nil {
+			return err
+		}
+		if expiresAt.Valid {
+			ur.ExpiresAt = timeFromString(expiresAt.String)
+		}
+		report.Roles = append(report.Roles, ur)
+		return nil
+	}, "SELECT role, expires_at FROM user_roles WHERE workspace = ? AND user = ?", ws, user)
+	if err != nil {
+		return err
+	}
+
+	err = collectRows(tx, func(rows *sql.Rows) error {
+		ut := rubix.UserTeam{Workspace: ws, User: user}
+		if err := rows.Scan(&ut.Team, &ut.Level); err != nil {
+			return err
+		}
+		report.Teams = append(report.Teams, ut)
+		return nil
+	}, "SELECT team, level FROM user_teams WHERE workspace = ? AND user = ?", ws, user)
+	if err != nil {
+		return err
+	}
+
+	if report.BPOs, err = collectStrings(tx, "SELECT bpo FROM bpo_managers WHERE workspace = ? AND user = ?", ws, user); err != nil {
+		return err
+	}
+	if report.Statuses, err = collectStrings(tx, "SELECT id FROM user_status WHERE workspace = ? AND user = ?", ws, user); err != nil {
+		return err
+	}
+	if report.AuthData, err = collectPaths(tx, "SELECT `vendor`, `app`, `key` FROM auth_data WHERE workspace = ? AND user = ?", ws, user); err != nil {
+		return err
+	}
+	if report.ActivationSteps, err = collectPaths(tx, "SELECT vendor, app, step_id FROM app_activation_state WHERE workspace = ? AND user = ?", ws, user); err != nil {
+		return err
+	}
+
+	if report.Attributes, err = collectStrings(tx, "SELECT attr_key FROM member_attributes WHERE workspace = ? AND user = ?", ws, user); err != nil {
+		return err
+	}
+
+	var directory int
+	if err = tx.QueryRow("SELECT COUNT(*) FROM workspace_users WHERE workspace = ? AND user_id = ?", ws, user).Scan(&directory); err != nil {
+		return err
+	}
+	report.DirectoryEntry = directory > 0
+
+	for _, query := range []string{
+		"DELETE FROM user_roles WHERE workspace = ? AND user = ?",
+		"DELETE FROM user_teams WHERE workspace = ? AND user = ?",
+		"DELETE FROM bpo_managers WHERE workspace = ? AND user = ?",
+		"DELETE FROM user_status WHERE workspace = ? AND user = ?",
+		"DELETE FROM auth_data WHERE workspace = ? AND user = ?",
+		"DELETE FROM app_activation_state WHERE workspace = ? AND user = ?",
+		"DELETE FROM workspace_users WHERE workspace = ? AND user_id = ?",
+		"DELETE FROM member_attributes WHERE workspace = ? AND user = ?",
+	} {
+		if _, err := tx.Exec(query, ws, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectRows(tx *sql.Tx, scan func(*sql.Rows) error, query string, args ...any) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func collectStrings(tx *sql.Tx, query string, args ...any) ([]string, error) {
+	var values []string
+	err := collectRows(tx, func(rows *sql.Rows) error {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		values = append(values, value)
+		return nil
+	}, query, args...)
+	return values, err
+}
+
+// collectPaths joins the vendor, app and key columns of each row as vendor/app/key
+func collectPaths(tx *sql.Tx, query string, args ...any) ([]string, error) {
+	var values []string
+	err := collectRows(tx, func(rows *sql.Rows) error {
+		var vendor, app, key sql.NullString
+		if err := rows.Scan(&vendor, &app, &key); err != nil {
+			return err
+		}
+		values = append(values, vendor.String+"/"+app.String+"/"+key.String)
+		return nil
+	}, query, args...)
+	return values, err
+}
@@ -0,0 +1,601 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+	"github.com/openbyte-os/sdk-go/app"
+)
+
+// roleGraph maps a role to the roles it directly inherits from
+type roleGraph map[string][]string
+
+// ancestry returns the role followed by every role it inherits from, nearest first.
+// Roles already visited are skipped, so inheritance cycles cannot loop forever.
+func (g roleGraph) ancestry(role string) []string {
+	chain, _ := g.lineage(role)
+	return chain
+}
+
+// lineage returns the same chain as ancestry, with the role each ancestor was first reached through
+func (g roleGraph) lineage(role string) ([]string, map[string]string) {
+	via := map[string]string{}
+	visited := map[string]bool{role: true}
+	chain := []string{role}
+	for i := 0; i < len(chain); i++ {
+		for _, parent := range g[chain[i]] {
+			if !visited[parent] {
+				visited[parent] = true
+				via[parent] = chain[i]
+				chain = append(chain, parent)
+			}
+		}
+	}
+	return chain, via
+}
+
+// createsCycle reports whether inheriting from parent would make role its own ancestor
+func (g roleGraph) createsCycle(role, parent string) bool {
+	return slices.Contains(g.ancestry(parent), role)
+}
+
+// roleDefinition holds the conditions and permissions declared directly on a role
+type roleDefinition struct {
+	conditions  rubix.Condition
+	permissions map[string]rubix.RolePermission
+}
+
+// permissionGrant is a single role's contribution to a user's permission
+type permissionGrant struct {
+	Role       string // Role assigned to the user
+	Source     string // Role defining the permission, differs from Role when inherited
+	Allow      bool
+	Options    map[string][]string
+	Conditions []rubix.Condition // Conditions of Role and of every role inherited through to Source
+}
+
+// permissionModel is an in-memory snapshot of role assignments and definitions,
+// evaluated without further queries
+type permissionModel struct {
+	workspace   string
+	assignments map[string][]string // user -> directly assigned roles
+	graph       roleGraph
+	roles       map[string]*roleDefinition
+}
+
+// loadPermissionModel loads the roles of the given users, every role they inherit
+// from and the requested permissions of those roles. An empty permissions list
+// loads all permissions.
+func (p *Provider) loadPermissionModel(workspace string, users []string, permissions []string) (*permissionModel, error) {
+	model := &permissionModel{workspace: workspace}
+
+	var err error
+	if model.assignments, err = p.loadRoleAssignments(workspace, users...); err != nil {
+		return nil, err
+	}
+	if model.graph, err = p.loadRoleGraph(workspace); err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	seen := make(map[string]bool)
+	for _, assigned := range model.assignments {
+		for _, role := range assigned {
+			for _, r := range model.graph.ancestry(role) {
+				if !seen[r] {
+					seen[r] = true
+					roles = append(roles, r)
+				}
+			}
+		}
+	}
+
+	if model.roles, err = p.loadRoleDefinitions(workspace, roles, permissions); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// loadRoleAssignments returns the unexpired roles directly assigned to each user, all users when none are given
+func (p *Provider) loadRoleAssignments(workspace string, users ...string) (map[string][]string, error) {
+	query := "SELECT user, role FROM user_roles WHERE workspace = ? AND (expires_at IS NULL OR expires_at > ?)"
+	args := []any{workspace, time.Now().UTC()}
+	if len(users) > 0 {
+		query += " AND user IN (?" + strings.Repeat(",?", len(users)-1) + ")"
+		for _, user := range users {
+			args = append(args, user)
+		}
+	}
+
+	rows, err := p.primaryConnection.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := make(map[string][]string)
+	for rows.Next() {
+		var user, role string
+		if err := rows.Scan(&user, &role); err != nil {
+			return nil, err
+		}
+		assignments[user] = append(assignments[user], role)
+	}
+	return assignments, rows.Err()
+}
+
+func (p *Provider) loadRoleGraph(workspace string) (roleGraph, error) {
+	rows, err := p.primaryConnection.Query("SELECT role, parent FROM role_parents WHERE workspace = ? ORDER BY role, parent", workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := make(roleGraph)
+	for rows.Next() {
+		var role, parent string
+		if err := rows.Scan(&role, &parent); err != nil {
+			return nil, err
+		}
+		graph[role] = append(graph[role], parent)
+	}
+	return graph, rows.Err()
+}
+
+// roleExists returns ErrNoResultFound, naming the role, when it is not defined in the workspace
+func (p *Provider) roleExists(workspace, role string) error {
+	var count int
+	if err := p.primaryConnection.QueryRow("SELECT COUNT(*) FROM roles WHERE workspace = ? AND role = ?", workspace, role).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("role %q: %w", role, rubix.ErrNoResultFound)
+	}
+	return nil
+}
+
+// loadRoleDefinitions loads the conditions and resource-less permissions of the given roles.
+// Roles that no longer exist are omitted.
+func (p *Provider) loadRoleDefinitions(workspace string, roles []string, permissions []string) (map[string]*roleDefinition, error) {
+	definitions := make(map[string]*roleDefinition)
+	if len(roles) == 0 {
+		return definitions, nil
+	}
+
+	var args []any
+	permFilter := ""
+	if len(permissions) > 0 {
+		permFilter = " AND rp.permission IN (?" + strings.Repeat(",?", len(permissions)-1) + ")"
+		for _, perm := range permissions {
+			args = append(args, perm)
+		}
+	}
+	args = append(args, workspace)
+	for _, role := range roles {
+		args = append(args, role)
+	}
+
+	query := "SELECT r.role, r.conditions, rp.permission, rp.allow, rp.options" +
+		" FROM roles AS r" +
+		" LEFT JOIN role_permissions AS rp ON rp.role = r.role AND rp.workspace = r.workspace" +
+		" AND rp.resource = ''" + permFilter +
+		" WHERE r.workspace = ? AND r.role IN (?" + strings.Repeat(",?", len(roles)-1) + ")"
+
+	rows, err := p.primaryConnection.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		var conditionsStr, permission, optionsStr sql.NullString
+		var allow sql.NullBool
+		if err := rows.Scan(&role, &conditionsStr, &permission, &allow, &optionsStr); err != nil {
+			return nil, err
+		}
+
+		def, ok := definitions[role]
+		if !ok {
+			def = &roleDefinition{permissions: make(map[string]rubix.RolePermission)}
+			if conditionsStr.Valid && conditionsStr.String != "" {
+				if err := json.Unmarshal([]byte(conditionsStr.String), &def.conditions); err != nil {
+					return nil, err
+				}
+			}
+			definitions[role] = def
+		}
+
+		if !permission.Valid {
+			continue
+		}
+
+		rp := rubix.RolePermission{Workspace: workspace, Role: role, Permission: permission.String, Allow: allow.Bool}
+		if optionsStr.Valid && optionsStr.String != "" {
+			if err := json.Unmarshal([]byte(optionsStr.String), &rp.Options); err != nil {
+				return nil, err
+			}
+		}
+		def.permissions[rp.Permission] = rp
+	}
+	return definitions, rows.Err()
+}
+
+// resolveRole returns the permissions reachable from a single assigned role.
+// The nearest definition wins, so a child role can override what a parent grants.
+// Inherited grants carry the conditions of every role on the path from the assigned role to the source.
+func (m *permissionModel) resolveRole(role string) map[string]permissionGrant {
+	if _, ok := m.roles[role]; !ok {
+		return nil
+	}
+
+	chain, via := m.graph.lineage(role)
+	grants := make(map[string]permissionGrant)
+	for _, source := range chain {
+		def, ok := m.roles[source]
+		if !ok {
+			continue
+		}
+		var conditions []rubix.Condition
+		for r := source; ; r = via[r] {
+			if d, ok := m.roles[r]; ok {
+				conditions = append(conditions, d.conditions)
+			}
+			if r == role {
+				break
+			}
+		}
+		slices.Reverse(conditions)
+
+		for key, rp := range def.permissions {
+			if _, defined := grants[key]; defined {
+				continue
+			}
+			grants[key] = permissionGrant{
+				Role:       role,
+				Source:     source,
+				Allow:      rp.Allow,
+				Options:    rp.Options,
+				Conditions: conditions,
+			}
+		}
+	}
+	return grants
+}
+
+// userGrants returns every grant for the user, keyed by permission
+func (m *permissionModel) userGrants(user string) map[string][]permissionGrant {
+	result := make(map[string][]permissionGrant)
+	for _, role := range m.assignments[user] {
+		for key, grant := range m.resolveRole(role) {
+			result[key] = append(result[key], grant)
+		}
+	}
+	return result
+}
+
+// permissionStatements converts grants into statements for the lookup.
+// Any deny wins, otherwise allows whose role conditions pass are merged.
+func permissionStatements(grants map[string][]permissionGrant, lookup rubix.Lookup, resolvers ...rubix.ConditionResolver) []app.PermissionStatement {
+	var statements []app.PermissionStatement
+	for key, permGrants := range grants {
+		denied := false
+		allowed := false
+		var options map[string][]string
+		for _, grant := range permGrants {
+			if !grant.Allow {
+				denied = true
+				break
+			}
+			if !grant.conditionsPass(lookup, resolvers...) {
+				continue
+			}
+			allowed = true
+			for opt, values := range grant.Options {
+				if options == nil {
+					options = make(map[string][]string)
+				}
+				options[opt] = append(options[opt], values...)
+			}
+		}
+
+		switch {
+		case denied:
+			statements = append(statements, app.PermissionStatement{
+				Effect:     app.PermissionEffectDeny,
+				Permission: app.ScopedKeyFromString(key),
+			})
+		case allowed:
+			statements = append(statements, app.PermissionStatement{
+				Effect:     app.PermissionEffectAllow,
+				Permission: app.ScopedKeyFromString(key),
+				Meta:       options,
+			})
+		}
+	}
+	return statements
+}
+
+func (g permissionGrant) conditionsPass(lookup rubix.Lookup, resolvers ...rubix.ConditionResolver) bool {
+	for _, condition := range g.Conditions {
+		if !rubix.CheckConditionWith(condition, lookup, resolvers...) {
+			return false
+		}
+	}
+	return true
+}
+
+// inheritedPermissions returns permissions a role receives from its ancestors
+// that it does not define itself
+func (m *permissionModel) inheritedPermissions(role string) []rubix.RolePermission {
+	var inherited []rubix.RolePermission
+	for key, grant := range m.resolveRole(role) {
+		if grant.Source == role {
+			continue
+		}
+		rp := m.roles[grant.Source].permissions[key]
+		inherited = append(inherited, rp)
+	}
+	slices.SortFunc(inherited, func(a, b rubix.RolePermission) int { return strings.Compare(a.Permission, b.Permission) })
+	return inherited
+}
+
+// GetPermissionDecisions evaluates the permissions for many lookups, returning decisions in
+// the same order as the lookups. Queries are batched per workspace rather than per lookup.
+func (p *Provider) GetPermissionDecisions(lookups []rubix.Lookup, permissions ...app.ScopedKey) ([]rubix.PermissionDecisions, error) {
+	keys := make([]string, 0, len(permissions))
+	for _, perm := range permissions {
+		keys = append(keys, perm.String())
+	}
+
+	results := make([]rubix.PermissionDecisions, len(lookups))
+	byWorkspace := make(map[string][]int)
+	for i, lookup := range lookups {
+		results[i] = rubix.PermissionDecisions{Lookup: lookup, Decisions: make(map[string]bool, len(keys))}
+		byWorkspace[lookup.WorkspaceUUID] = append(byWorkspace[lookup.WorkspaceUUID], i)
+	}
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	for workspace, indexes := range byWorkspace {
+		var users []string
+		for _, i := range indexes {
+			if !slices.Contains(users, lookups[i].UserUUID) {
+				users = append(users, lookups[i].UserUUID)
+			}
+		}
+
+		model, err := p.loadPermissionModel(workspace, users, keys)
+		if err != nil {
+			return nil, err
+		}
+		if model.requiresPrincipal() {
+			workspaceLookups := make([]rubix.Lookup, len(indexes))
+			for j, i := range indexes {
+				workspaceLookups[j] = lookups[i]
+			}
+			if err = p.loadPrincipals(workspace, workspaceLookups); err != nil {
+				return nil, err
+			}
+			for j, i := range indexes {
+				results[i].Lookup.Principal = workspaceLookups[j].Principal
+			}
+		}
+
+		resolvers, err := p.conditionResolvers(workspace)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range indexes {
+			for _, key := range keys {
+				results[i].Decisions[key] = false
+			}
+			for _, statement := range permissionStatements(model.userGrants(lookups[i].UserUUID), results[i].Lookup, resolvers...) {
+				results[i].Decisions[statement.Permission.String()] = statement.Effect == app.PermissionEffectAllow
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// GetUsersPermissionDecisions evaluates the permissions for each user, using the lookup for the
+// session context (location, IP, MFA) of every user. Results are keyed by user ID.
+func (p *Provider) GetUsersPermissionDecisions(lookup rubix.Lookup, userIDs []string, permissions ...app.ScopedKey) (map[string]rubix.PermissionDecisions, error) {
+	lookups := make([]rubix.Lookup, 0, len(userIDs))
+	for _, userID := range userIDs {
+		userLookup := lookup
+		userLookup.UserUUID = userID
+		lookups = append(lookups, userLookup)
+	}
+
+	decisions, err := p.GetPermissionDecisions(lookups, permissions...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]rubix.PermissionDecisions, len(decisions))
+	for _, d := range decisions {
+		result[d.Lookup.UserUUID] = d
+	}
+	return result, nil
+}
+
+// GetWorkspacePermissionMatrix reports the effective permissions of every workspace member,
+// all permissions when none are given. Role conditions cannot be evaluated without a session,
+// so allows that depend on them are flagged as conditional along with the conditions that apply.
+func (p *Provider) GetWorkspacePermissionMatrix(workspace string, permissions ...app.ScopedKey) (*rubix.PermissionMatrix, error) {
+	keys := make([]string, 0, len(permissions))
+	for _, perm := range permissions {
+		keys = append(keys, perm.String())
+	}
+
+	members, err := p.GetWorkspaceMembers(workspace)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(members, func(a, b rubix.Membership) int { return strings.Compare(a.UserID, b.UserID) })
+
+	model, err := p.loadPermissionModel(workspace, nil, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := &rubix.PermissionMatrix{Workspace: workspace, GeneratedAt: time.Now()}
+	for _, member := range members {
+		grants := model.userGrants(member.UserID)
+		perms := make([]string, 0, len(grants))
+		for key := range grants {
+			perms = append(perms, key)
+		}
+		slices.Sort(perms)
+		for _, key := range perms {
+			matrix.Entries = append(matrix.Entries, matrixEntry(member, key, grants[key]))
+		}
+	}
+	return matrix, nil
+}
+
+// matrixEntry applies the same precedence as permissionStatements, any deny wins
+func matrixEntry(member rubix.Membership, permission string, grants []permissionGrant) rubix.PermissionMatrixEntry {
+	entry := rubix.PermissionMatrixEntry{
+		UserID:      member.UserID,
+		Name:        member.Name,
+		Email:       member.Email,
+		Permission:  permission,
+		Effect:      app.PermissionEffectAllow,
+		Conditional: true,
+	}
+	for _, grant := range grants {
+		pg := rubix.PermissionGrant{Role: grant.Role, Allow: grant.Allow}
+		if grant.Source != grant.Role {
+			pg.InheritedFrom = grant.Source
+		}
+		unconditional := true
+		for _, c := range grant.Conditions {
+			if !c.Empty() {
+				pg.Conditions = append(pg.Conditions, c)
+				unconditional = false
+			}
+		}
+		if !grant.Allow {
+			entry.Effect = app.PermissionEffectDeny
+		} else if unconditional {
+			entry.Conditional = false
+		}
+		entry.Grants = append(entry.Grants, pg)
+	}
+	if entry.Effect == app.PermissionEffectDeny {
+		entry.Conditional = false
+	}
+	return entry
+}
+
+// GetAccessibleResources returns the IDs of resources of the given type linked to the roles
+// through which the user holds the permission. Resources are taken from the role assigned to
+// the user, including when the permission itself is inherited from a parent role.
+func (p *Provider) GetAccessibleResources(lookup rubix.Lookup, permission app.ScopedKey, resourceType rubix.ResourceType) ([]string, error) {
+	model, err := p.loadPermissionModel(lookup.WorkspaceUUID, []string{lookup.UserUUID}, []string{permission.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	if model.requiresPrincipal() {
+		lookups := []rubix.Lookup{lookup}
+		if err = p.loadPrincipals(lookup.WorkspaceUUID, lookups); err != nil {
+			return nil, err
+		}
+		lookup = lookups[0]
+	}
+
+	resolvers, err := p.conditionResolvers(lookup.WorkspaceUUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, grant := range model.userGrants(lookup.UserUUID)[permission.String()] {
+		if !grant.Allow {
+			return nil, nil
+		}
+		if grant.conditionsPass(lookup, resolvers...) && !slices.Contains(roles, grant.Role) {
+			roles = append(roles, grant.Role)
+		}
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	args := []any{lookup.WorkspaceUUID, string(resourceType)}
+	for _, role := range roles {
+		args = append(args, role)
+	}
+	rows, err := p.primaryConnection.Query("SELECT DISTINCT resource FROM role_resources WHERE workspace = ? AND resource_type = ? AND role IN (?"+strings.Repeat(",?", len(roles)-1)+") ORDER BY resource", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resources []string
+	for rows.Next() {
+		var resource string
+		if err := rows.Scan(&resource); err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return resources, rows.Err()
+}
+
+// requiresPrincipal reports whether any loaded role condition filters on principal attributes
+func (m *permissionModel) requiresPrincipal() bool {
+	for _, role := range m.roles {
+		if role.conditions.RequiresPrincipal() {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPrincipals sets the principal of each lookup from the user's membership and attributes,
+// replacing any supplied by the caller. Users who are not members are left without a principal,
+// failing principal conditions.
+func (p *Provider) loadPrincipals(workspace string, lookups []rubix.Lookup) error {
+	var users []string
+	for _, lookup := range lookups {
+		if lookup.UserUUID != "" && !slices.Contains(users, lookup.UserUUID) {
+			users = append(users, lookup.UserUUID)
+		}
+	}
+	if len(users) == 0 {
+		for i := range lookups {
+			lookups[i].Principal = nil
+		}
+		return nil
+	}
+
+	members, err := p.GetWorkspaceMembers(workspace, users...)
+	if err != nil {
+		return err
+	}
+	schema, err := p.memberAttributeSchema(workspace)
+	if err != nil {
+		return err
+	}
+	if err = p.loadMemberAttributes(workspace, schema, members); err != nil {
+		return err
+	}
+	principals := make(map[string]*rubix.Principal, len(members))
+	for _, member := range members {
+		principals[member.UserID] = rubix.NewPrincipal(member, schema)
+	}
+	for i := range lookups {
+		lookups[i].Principal = principals[lookups[i].UserUUID]
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// grantRole assigns the role to the user, expiring at expiry unless it is zero.
+// Re-granting an existing assignment only extends it, a permanent or later expiry is kept
+// unless shorten is set. It reports whether anything changed.
+func (p *Provider) grantRole(workspace, user, role string, expiry time.Time, shorten bool) (bool, error) {
+	expiresAt := nullTime(expiry)
+
+	_, err := p.primaryConnection.Exec("INSERT INTO user_roles (workspace, user, role, expires_at) VALUES (?, ?, ?, ?)", workspace, user, role, expiresAt)
+	if !p.isDuplicateConflict(err) {
+		return err == nil, err
+	}
+
+	current := sql.NullString{}
+	if err = p.primaryConnection.QueryRow("SELECT expires_at FROM user_roles WHERE workspace = ? AND user = ? AND role = ?", workspace, user, role).Scan(&current); err != nil {
+		return false, err
+	}
+	currentExpiry := time.Time{}
+	if current.Valid && current.String != "" {
+		currentExpiry = timeFromString(current.String)
+	}
+	if currentExpiry.Equal(expiresAt.Time) {
+		return false, nil
+	}
+	// A zero expiry is permanent, so it outlasts any date
+	extends := expiresAt.Time.IsZero() || (!currentExpiry.IsZero() && expiresAt.Time.After(currentExpiry))
+	if !extends && !shorten {
+		return false, nil
+	}
+
+	_, err = p.primaryConnection.Exec("UPDATE user_roles SET expires_at = ? WHERE workspace = ? AND user = ? AND role = ?", expiresAt, workspace, user, role)
+	return err == nil, err
+}
+
+// SweepExpiredRoleGrants removes role assignments that have passed their expiry across all
+// workspaces, returning the removed assignments. AfterUpdate handlers are notified when
+// anything was removed.
+func (p *Provider) SweepExpiredRoleGrants() ([]rubix.UserRole, error) {
+	now := time.Now().UTC()
+	rows, err := p.primaryConnection.Query("SELECT workspace, user, role, expires_at FROM user_roles WHERE expires_at IS NOT NULL AND expires_at <= ?", now)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []rubix.UserRole
+	for rows.Next() {
+		var ur rubix.UserRole
+		expiresAt := sql.NullString{}
+		if err := rows.Scan(&ur.Workspace, &ur.User, &ur.Role, &expiresAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ur.ExpiresAt = timeFromString(expiresAt.String)
+		expired = append(expired, ur)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var removed []rubix.UserRole
+	for _, ur := range expired {
+		// Re-check the expiry so a grant extended since the select is kept
+		res, err := p.primaryConnection.Exec("DELETE FROM user_roles WHERE workspace = ? AND user = ? AND role = ? AND expires_at IS NOT NULL AND expires_at <= ?", ur.Workspace, ur.User, ur.Role, now)
+		if err != nil {
+			return removed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if _, err := p.primaryConnection.Exec("UPDATE workspace_memberships SET lastUpdate = CURRENT_TIMESTAMP WHERE workspace = ? AND user = ?", ur.Workspace, ur.User); err != nil {
+			return removed, err
+		}
+		removed = append(removed, ur)
+	}
+
+	if len(removed) > 0 {
+		p.update()
+	}
+	return removed, nil
+}
@@ -446,5 +446,149 @@ func migrations() []migration {
 
 	queries = append(queries, migQuery("ALTER TABLE `roles` ADD `blueprint_key` varchar(255) NOT NULL DEFAULT '';"))
 
+	// Role inheritance
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `role_parents` ("+
+		"`workspace` varchar(64) NOT NULL,"+
+		"`role`      varchar(64) NOT NULL,"+
+		"`parent`    varchar(64) NOT NULL,"+
+		"PRIMARY KEY (`workspace`, `role`, `parent`)"+
+		");"))
+
+	// Time-bound role assignments
+	queries = append(queries, migQuery("ALTER TABLE `user_roles` ADD `expires_at` datetime NULL;"))
+
+	// Role access requests
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `role_access_requests` ("+
+		"`workspace`        varchar(64)  NOT NULL,"+
+		"`id`               varchar(64)  NOT NULL,"+
+		"`requester`        varchar(64)  NOT NULL,"+
+		"`role`             varchar(64)  NOT NULL,"+
+		"`justification`    text         NOT NULL DEFAULT '',"+
+		"`duration`         int          NOT NULL DEFAULT 0,"+
+		"`state`            varchar(20)  NOT NULL DEFAULT 'pending',"+
+		"`created_at`       datetime     NOT NULL DEFAULT CURRENT_TIMESTAMP,"+
+		"`decided_by`       varchar(64)  NOT NULL DEFAULT '',"+
+		"`decided_at`       datetime     NULL,"+
+		"`decision_reason`  text         NOT NULL DEFAULT '',"+
+		"`grant_expires_at` datetime     NULL,"+
+		"PRIMARY KEY (`workspace`, `id`)"+
+		");"))
+	queries = append(queries, migQuery("CREATE INDEX `rar_workspace_state` ON `role_access_requests`(`workspace`, `state`);"))
+	queries = append(queries, migQuery("ALTER TABLE `workspaces` ADD `accessRequestApprover` varchar(255) NOT NULL DEFAULT '';"))
+
+	// External IP group sync
+	queries = append(queries, migQuery("ALTER TABLE `ip_groups` ADD `syncError` text NULL;"))
+
+	// IP group entry normalisation
+	queries = append(queries, migQuery("ALTER TABLE `ip_groups` ADD `collapseOverlaps` tinyint(1) NOT NULL DEFAULT 0;"))
+
+	// Member approval decisions
+	queries = append(queries, migQuery("ALTER TABLE `workspace_memberships` ADD `decided_by` varchar(64) NOT NULL DEFAULT '';"))
+	queries = append(queries, migQuery("ALTER TABLE `workspace_memberships` ADD `decided_at` datetime NULL;"))
+	queries = append(queries, migQuery("ALTER TABLE `workspace_memberships` ADD `decision_reason` text NULL;"))
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `member_approval_defaults` ("+
+		"`workspace` varchar(64)  NOT NULL,"+
+		"`domain`    varchar(255) NOT NULL DEFAULT '',"+
+		"`roles`     text         NULL,"+
+		"`teams`     text         NULL,"+
+		"PRIMARY KEY (`workspace`, `domain`)"+
+		");"))
+
+	// Location groups
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `location_groups` ("+
+		"`workspace`      varchar(64)  NOT NULL,"+
+		"`location_group` varchar(64)  NOT NULL,"+
+		"`name`           varchar(64)  NOT NULL,"+
+		"`description`    varchar(255) NOT NULL DEFAULT '',"+
+		"`locations`      text         NULL,"+
+		"PRIMARY KEY (`workspace`, `location_group`)"+
+		");"))
+
+	// Workspace invitations
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `workspace_invitations` ("+
+		"`workspace`    varchar(64)  NOT NULL,"+
+		"`id`           varchar(64)  NOT NULL,"+
+		"`email`        varchar(255) NOT NULL,"+
+		"`type`         varchar(20)  NOT NULL,"+
+		"`partner_id`   varchar(64)  NOT NULL DEFAULT '',"+
+		"`roles`        text         NULL,"+
+		"`teams`        text         NULL,"+
+		"`invited_by`   varchar(64)  NOT NULL DEFAULT '',"+
+		"`state`        varchar(20)  NOT NULL,"+
+		"`token_hash`   varchar(64)  NOT NULL,"+
+		"`created_at`   datetime     NOT NULL DEFAULT CURRENT_TIMESTAMP,"+
+		"`expires_at`   datetime     NOT NULL,"+
+		"`last_sent_at` datetime     NULL,"+
+		"`send_count`   int          NOT NULL DEFAULT 0,"+
+		"`accepted_by`  varchar(64)  NOT NULL DEFAULT '',"+
+		"`accepted_at`  datetime     NULL,"+
+		"PRIMARY KEY (`workspace`, `id`)"+
+		");"))
+	queries = append(queries, migQuery("CREATE UNIQUE INDEX `wi_token_hash` ON `workspace_invitations`(`token_hash`);"))
+	queries = append(queries, migQuery("CREATE INDEX `wi_workspace_email` ON `workspace_invitations`(`workspace`, `email`);"))
+
+	// Membership state history
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `workspace_membership_history` ("+
+		"`workspace`  varchar(64)  NOT NULL,"+
+		"`user`       varchar(64)  NOT NULL,"+
+		"`kind`       varchar(20)  NOT NULL,"+
+		"`from_state` int          NOT NULL DEFAULT 0,"+
+		"`to_state`   int          NOT NULL DEFAULT 0,"+
+		"`from_type`  varchar(20)  NOT NULL DEFAULT '',"+
+		"`to_type`    varchar(20)  NOT NULL DEFAULT '',"+
+		"`actor`      varchar(64)  NOT NULL DEFAULT '',"+
+		"`reason`     text         NOT NULL DEFAULT '',"+
+		"`changed_at` datetime     NOT NULL DEFAULT CURRENT_TIMESTAMP,"+
+		"`seq`        bigint       NOT NULL DEFAULT 0"+
+		");"))
+	queries = append(queries, migQuery("CREATE INDEX `wmh_workspace_user` ON `workspace_membership_history`(`workspace`, `user`, `seq`);"))
+
+	// Member offboarding reports
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `member_offboarding` ("+
+		"`workspace`     varchar(64) NOT NULL,"+
+		"`user`          varchar(64) NOT NULL,"+
+		"`offboarded_at` datetime    NOT NULL,"+
+		"`actor`         varchar(64) NOT NULL DEFAULT '',"+
+		"`reason`        text        NOT NULL DEFAULT '',"+
+		"`report`        text        NOT NULL"+
+		");"))
+	queries = append(queries, migQuery("CREATE INDEX `mo_workspace_user` ON `member_offboarding`(`workspace`, `user`);"))
+
+	// Membership expiry
+	queries = append(queries, migQuery("ALTER TABLE `workspace_memberships` ADD `expires_at` datetime NULL;"))
+	queries = append(queries, migQuery("ALTER TABLE `workspace_memberships` ADD `expiry_action` varchar(10) NOT NULL DEFAULT '';"))
+	queries = append(queries, migQuery("CREATE INDEX `wm_expires_at` ON `workspace_memberships`(`expires_at`);"))
+	queries = append(queries, migQuery("ALTER TABLE `workspace_membership_history` ADD `from_expiry` datetime NULL;"))
+	queries = append(queries, migQuery("ALTER TABLE `workspace_membership_history` ADD `to_expiry` datetime NULL;"))
+
+	// Custom member attributes
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `member_attribute_schema` ("+
+		"`workspace`   varchar(64)  NOT NULL,"+
+		"`attr_key`    varchar(64)  NOT NULL,"+
+		"`name`        varchar(64)  NOT NULL DEFAULT '',"+
+		"`description` varchar(255) NOT NULL DEFAULT '',"+
+		"`type`        varchar(10)  NOT NULL,"+
+		"`options`     text         NULL,"+
+		"PRIMARY KEY (`workspace`, `attr_key`)"+
+		");"))
+	queries = append(queries, migQuery("CREATE TABLE IF NOT EXISTS `member_attributes` ("+
+		"`workspace` varchar(64) NOT NULL,"+
+		"`user`      varchar(64) NOT NULL,"+
+		"`attr_key`  varchar(64) NOT NULL,"+
+		"`value`     text        NOT NULL,"+
+		"PRIMARY KEY (`workspace`, `user`, `attr_key`)"+
+		");"))
+	queries = append(queries, migQuery("CREATE INDEX `ma_workspace_key` ON `member_attributes`(`workspace`, `attr_key`);"))
+
+	// Membership history sequence, per workspace
+	queries = append(queries, migQuery("CREATE UNIQUE INDEX `wmh_workspace_seq` ON `workspace_membership_history`(`workspace`, `seq`);"))
+
+	// User profile
+	queries = append(queries, migQuery("ALTER TABLE `users` ADD `locale` varchar(35) NOT NULL DEFAULT '';"))
+	queries = append(queries, migQuery("ALTER TABLE `users` ADD `timezone` varchar(64) NOT NULL DEFAULT '';"))
+	queries = append(queries, migQuery("ALTER TABLE `users` ADD `avatar_url` varchar(512) NOT NULL DEFAULT '';"))
+	queries = append(queries, migQuery("ALTER TABLE `users` ADD `phone` varchar(20) NOT NULL DEFAULT '';"))
+	queries = append(queries, migQuery("ALTER TABLE `users` ADD `preferences` text NULL;"))
+
 	return queries
 }
@@ -0,0 +1,209 @@
+package sql
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/kubex/rubix-storage/rubix"
+	"github.com/openbyte-os/sdk-go/app"
+)
+
+// SimulateRoleChange previews MutateRole, returning the permission delta of every affected user.
+// Nothing is persisted; the change is applied to an in-memory copy of the workspace permission model.
+func (p *Provider) SimulateRoleChange(workspace, role string, options ...rubix.MutateRoleOption) (*rubix.PolicySimulation, error) {
+	payload := rubix.MutateRolePayload{}
+	for _, opt := range options {
+		opt(&payload)
+	}
+	if payload.Conditions != nil {
+		if err := payload.Conditions.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.simulate(workspace, append([]string{role}, payload.ParentsToAdd...), func(m *permissionModel) error {
+		def, ok := m.roles[role]
+		if !ok {
+			return rubix.ErrNoResultFound
+		}
+
+		for _, user := range payload.UsersToAdd {
+			if !slices.Contains(m.assignments[user], role) {
+				m.assignments[user] = append(m.assignments[user], role)
+			}
+		}
+		for _, user := range payload.UsersToRem {
+			m.assignments[user] = slices.DeleteFunc(m.assignments[user], func(r string) bool { return r == role })
+		}
+
+		for _, perm := range payload.PermsToRem {
+			delete(def.permissions, perm)
+		}
+		for _, perm := range payload.PermsToAdd {
+			if _, exists := def.permissions[perm]; !exists {
+				def.permissions[perm] = rubix.RolePermission{Workspace: workspace, Role: role, Permission: perm, Allow: true}
+			}
+		}
+		for _, perm := range payload.PermsToDeny {
+			rp := def.permissions[perm]
+			rp.Workspace, rp.Role, rp.Permission, rp.Allow = workspace, role, perm, false
+			def.permissions[perm] = rp
+		}
+		for perm, options := range payload.PermOptionToAdd {
+			if rp, exists := def.permissions[perm]; exists {
+				rp.Options = options
+				def.permissions[perm] = rp
+			}
+		}
+		if payload.Conditions != nil {
+			def.conditions = *payload.Conditions
+		}
+
+		m.graph[role] = slices.DeleteFunc(m.graph[role], func(parent string) bool { return slices.Contains(payload.ParentsToRem, parent) })
+		for _, parent := range payload.ParentsToAdd {
+			if _, ok := m.roles[parent]; !ok {
+				return fmt.Errorf("role %q: %w", parent, rubix.ErrNoResultFound)
+			}
+			if parent == role || m.graph.createsCycle(role, parent) {
+				return rubix.ErrRoleInheritanceCycle
+			}
+			if !slices.Contains(m.graph[role], parent) {
+				m.graph[role] = append(m.graph[role], parent)
+			}
+		}
+		return nil
+	})
+}
+
+// SimulateUserChange previews the role changes of MutateUser for a single user
+func (p *Provider) SimulateUserChange(workspace, user string, options ...rubix.MutateUserOption) (*rubix.PolicySimulation, error) {
+	payload := rubix.MutateUserPayload{}
+	for _, opt := range options {
+		opt(&payload)
+	}
+
+	return p.simulate(workspace, payload.RolesToAdd, func(m *permissionModel) error {
+		for _, role := range payload.RolesToAdd {
+			if _, ok := m.roles[role]; ok && !slices.Contains(m.assignments[user], role) {
+				m.assignments[user] = append(m.assignments[user], role)
+			}
+		}
+		m.assignments[user] = slices.DeleteFunc(m.assignments[user], func(r string) bool { return slices.Contains(payload.RolesToRemove, r) })
+		return nil
+	})
+}
+
+// simulate applies the change to a copy of the workspace permission model and compares the
+// effective access of every user before and after. Roles the change refers to are loaded
+// along with their ancestry even when nobody holds them yet.
+func (p *Provider) simulate(workspace string, referencedRoles []string, change func(m *permissionModel) error) (*rubix.PolicySimulation, error) {
+	before, err := p.loadPermissionModel(workspace, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, role := range referencedRoles {
+		for _, r := range before.graph.ancestry(role) {
+			if _, loaded := before.roles[r]; !loaded && !slices.Contains(missing, r) {
+				missing = append(missing, r)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		extra, err := p.loadRoleDefinitions(workspace, missing, nil)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(before.roles, extra)
+	}
+
+	after := before.clone()
+	if err := change(after); err != nil {
+		return nil, err
+	}
+
+	members, err := p.GetWorkspaceMembers(workspace)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]rubix.Membership, len(members))
+	for _, member := range members {
+		profiles[member.UserID] = member
+	}
+
+	users := slices.Collect(maps.Keys(profiles))
+	for _, model := range []*permissionModel{before, after} {
+		for user := range model.assignments {
+			if !slices.Contains(users, user) {
+				users = append(users, user)
+			}
+		}
+	}
+	slices.Sort(users)
+
+	simulation := &rubix.PolicySimulation{Workspace: workspace}
+	for _, user := range users {
+		member, ok := profiles[user]
+		if !ok {
+			member = rubix.Membership{UserID: user}
+		}
+		beforeGrants, afterGrants := before.userGrants(user), after.userGrants(user)
+
+		perms := slices.Collect(maps.Keys(beforeGrants))
+		for perm := range afterGrants {
+			if _, ok := beforeGrants[perm]; !ok {
+				perms = append(perms, perm)
+			}
+		}
+		slices.Sort(perms)
+
+		delta := rubix.UserPermissionDelta{UserID: user, Name: member.Name, Email: member.Email}
+		for _, perm := range perms {
+			was, now := permissionAccess(member, perm, beforeGrants[perm]), permissionAccess(member, perm, afterGrants[perm])
+			if was != now {
+				delta.Changes = append(delta.Changes, rubix.PermissionChange{Permission: perm, Before: was, After: now})
+			}
+		}
+		if len(delta.Changes) > 0 {
+			simulation.Users = append(simulation.Users, delta)
+		}
+	}
+	return simulation, nil
+}
+
+// permissionAccess summarises grants with the same precedence as the permission matrix
+func permissionAccess(member rubix.Membership, permission string, grants []permissionGrant) rubix.PermissionAccess {
+	if len(grants) == 0 {
+		return rubix.PermissionAccessNone
+	}
+	entry := matrixEntry(member, permission, grants)
+	switch {
+	case entry.Effect == app.PermissionEffectDeny:
+		return rubix.PermissionAccessDeny
+	case entry.Conditional:
+		return rubix.PermissionAccessConditional
+	}
+	return rubix.PermissionAccessAllow
+}
+
+// clone copies the model deeply enough for a simulated change not to affect the original
+func (m *permissionModel) clone() *permissionModel {
+	c := &permissionModel{
+		workspace:   m.workspace,
+		assignments: make(map[string][]string, len(m.assignments)),
+		graph:       make(roleGraph, len(m.graph)),
+		roles:       make(map[string]*roleDefinition, len(m.roles)),
+	}
+	for user, roles := range m.assignments {
+		c.assignments[user] = slices.Clone(roles)
+	}
+	for role, parents := range m.graph {
+		c.graph[role] = slices.Clone(parents)
+	}
+	for role, def := range m.roles {
+		c.roles[role] = &roleDefinition{conditions: def.conditions, permissions: maps.Clone(def.permissions)}
+	}
+	return c
+}
@@ -21,6 +21,7 @@ type Provider struct {
 	SqlLite           bool   `json:"sqlLite"`
 	primaryConnection *sql.DB
 	afterUpdate       []func()
+	ipMatchers        ipMatcherCache
 }
 
 func (p *Provider) Close() error {
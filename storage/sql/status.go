@@ -9,6 +9,15 @@ import (
 )
 
 func (p *Provider) SetUserStatus(workspaceUuid, userUuid string, status rubix.UserStatus) (bool, error) {
+	if status.ClearEndOfDay && status.ExpiryTime.IsZero() && status.AfterID == "" {
+		// End of day is local to the user, UTC when they have no time zone set
+		profile, err := p.userProfile(userUuid)
+		if err != nil {
+			return false, err
+		}
+		status.ExpiryTime = profile.EndOfDay(time.Now())
+	}
+
 	var expiry *time.Time
 	duration := status.ClearAfterSeconds
 	if !status.ExpiryTime.IsZero() {
@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"database/sql"
 	"time"
 )
 
@@ -26,3 +27,8 @@ func timeFromString(in string) time.Time {
 	}
 	return time.Time{}
 }
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
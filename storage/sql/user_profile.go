@@ -0,0 +1,97 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"strings"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// userProfileColumns are selected from the users table, aliased as u, and scanned by profileScanner
+const userProfileColumns = "u.locale, u.timezone, u.avatar_url, u.phone, u.preferences"
+
+// profileScanner collects nullable profile columns, users may be missing from LEFT JOINs
+type profileScanner struct {
+	locale, timeZone, avatarURL, phone, preferences sql.NullString
+}
+
+func (s *profileScanner) dest() []any {
+	return []any{&s.locale, &s.timeZone, &s.avatarURL, &s.phone, &s.preferences}
+}
+
+func (s *profileScanner) profile() rubix.UserProfile {
+	profile := rubix.UserProfile{
+		Locale:    s.locale.String,
+		TimeZone:  s.timeZone.String,
+		AvatarURL: s.avatarURL.String,
+		Phone:     s.phone.String,
+	}
+	if s.preferences.Valid && s.preferences.String != "" {
+		_ = json.Unmarshal([]byte(s.preferences.String), &profile.Preferences)
+	}
+	return profile
+}
+
+// updateUserProfile writes the name, email and profile fields of the payload, merging preferences
+// into those already stored
+func (p *Provider) updateUserProfile(user string, payload rubix.MutateUserPayload) error {
+	var fields []string
+	var vals []any
+	set := func(column string, value *string) {
+		if value != nil {
+			fields = append(fields, column+" = ?")
+			vals = append(vals, *value)
+		}
+	}
+	set("name", payload.Name)
+	set("email", payload.Email)
+	set("locale", payload.Locale)
+	set("timezone", payload.TimeZone)
+	set("avatar_url", payload.AvatarURL)
+	set("phone", payload.Phone)
+
+	if len(payload.Preferences) > 0 {
+		stored := sql.NullString{}
+		err := p.primaryConnection.QueryRow("SELECT preferences FROM users WHERE user = ?", user).Scan(&stored)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		preferences := map[string]string{}
+		if stored.Valid && stored.String != "" {
+			_ = json.Unmarshal([]byte(stored.String), &preferences)
+		}
+		maps.Copy(preferences, payload.Preferences)
+		maps.DeleteFunc(preferences, func(_, value string) bool { return value == "" })
+
+		encoded := sql.NullString{}
+		if len(preferences) > 0 {
+			raw, err := json.Marshal(preferences)
+			if err != nil {
+				return err
+			}
+			encoded = sql.NullString{String: string(raw), Valid: true}
+		}
+		fields = append(fields, "preferences = ?")
+		vals = append(vals, encoded)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	vals = append(vals, user)
+	_, err := p.primaryConnection.Exec("UPDATE users SET "+strings.Join(fields, ", ")+" WHERE user = ?", vals...)
+	return err
+}
+
+// userProfile returns the global profile of the user, empty when the user is unknown
+func (p *Provider) userProfile(user string) (rubix.UserProfile, error) {
+	scanner := profileScanner{}
+	err := p.primaryConnection.QueryRow("SELECT "+userProfileColumns+" FROM users AS u WHERE u.user = ?", user).Scan(scanner.dest()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rubix.UserProfile{}, nil
+	}
+	return scanner.profile(), err
+}
@@ -0,0 +1,144 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kubex/rubix-storage/rubix"
+)
+
+// CheckWorkspaceAccess combines the workspace gatekeeping rules into a single decision.
+// The OIDC provider, when given, can vouch for MFA and verification and bound the session age.
+// Members are checked by state, others by email domain eligibility and the approval mode,
+// and everyone must satisfy the workspace access condition.
+func (p *Provider) CheckWorkspaceAccess(lookup rubix.Lookup, email, providerUUID string) (*rubix.WorkspaceAccessDecision, error) {
+	workspace, err := p.RetrieveWorkspace(lookup.WorkspaceUUID)
+	if err != nil {
+		return nil, err
+	}
+	if workspace == nil {
+		return nil, rubix.ErrNoResultFound
+	}
+
+	decision := &rubix.WorkspaceAccessDecision{}
+	var denied, pending []string
+
+	var provider *rubix.OIDCProvider
+	if providerUUID != "" {
+		if provider, err = p.GetOIDCProvider(workspace.Uuid, providerUUID); errors.Is(err, rubix.ErrNoResultFound) {
+			denied = append(denied, "identity provider is not configured for this workspace")
+		} else if err != nil {
+			return nil, err
+		} else {
+			lookup.MFA = lookup.MFA || provider.AssumeMFA
+			lookup.VerifiedAccount = lookup.VerifiedAccount || provider.AssumeVerified
+			if provider.MaxSessionAge > 0 && lookup.Now().Unix()-lookup.SessionIssued.Unix() > int64(provider.MaxSessionAge) {
+				denied = append(denied, fmt.Sprintf("session is older than %d seconds", provider.MaxSessionAge))
+			}
+		}
+	}
+
+	var membership *rubix.Membership
+	if lookup.UserUUID != "" {
+		if membership, err = p.getWorkspaceMember(workspace.Uuid, lookup.UserUUID); err != nil && !errors.Is(err, rubix.ErrNoResultFound) {
+			return nil, err
+		}
+	}
+	if membership != nil {
+		decision.Membership = membership
+		switch {
+		case membership.Expired(lookup.Now()):
+			// Expired memberships lose access before the sweeper applies the expiry action
+			denied = append(denied, "membership expired")
+		case membership.State == rubix.MembershipStateActive:
+		case membership.State == rubix.MembershipStatePending:
+			pending = append(pending, "membership is awaiting approval")
+		default:
+			denied = append(denied, "membership is "+strings.ToLower(membership.State.Display()))
+		}
+	} else if lookup.UserUUID == "" {
+		denied = append(denied, "user is required")
+	} else {
+		if email == "" {
+			denied = append(denied, "not a member of this workspace")
+		} else if reason, eligible := memberEligibility(workspace, email, provider); !eligible {
+			denied = append(denied, reason)
+		} else if memberApprovalMode(workspace, email, provider) == rubix.MemberApprovalModeQueue {
+			pending = append(pending, "membership requires approval")
+		}
+	}
+
+	// Principal conditions are evaluated against the stored membership, never the caller's principal.
+	// Without a membership they cannot be evaluated, so negated principal filters do not pass either.
+	lookup.Principal = nil
+	if workspace.AccessCondition.RequiresPrincipal() {
+		lookups := []rubix.Lookup{lookup}
+		if err = p.loadPrincipals(workspace.Uuid, lookups); err != nil {
+			return nil, err
+		}
+		lookup = lookups[0]
+	}
+	if workspace.AccessCondition.RequiresPrincipal() && lookup.Principal == nil {
+		failure := rubix.ConditionFailure{Clause: rubix.ConditionClausePrincipal, Message: "member details are not available"}
+		decision.Conditions = []rubix.ConditionFailure{failure}
+		denied = append(denied, failure.Message)
+	} else if !workspace.AccessCondition.Empty() {
+		resolvers, err := p.conditionResolvers(workspace.Uuid)
+		if err != nil {
+			return nil, err
+		}
+		result := rubix.CheckConditionDetailed(workspace.AccessCondition, lookup, resolvers...)
+		decision.Conditions = result.Failures
+		denied = append(denied, result.Messages()...)
+	}
+
+	switch {
+	case len(denied) > 0:
+		decision.Result, decision.Reasons = rubix.WorkspaceAccessDeny, denied
+	case len(pending) > 0:
+		decision.Result, decision.Reasons = rubix.WorkspaceAccessPendingApproval, pending
+	default:
+		decision.Result = rubix.WorkspaceAccessAllow
+	}
+	return decision, nil
+}
+
+func emailDomain(email string) string {
+	_, domain, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	return domain
+}
+
+// memberEligibility reports whether a non-member may join, through the workspace identity provider or their email domain
+func memberEligibility(workspace *rubix.Workspace, email string, provider *rubix.OIDCProvider) (string, bool) {
+	if provider != nil {
+		return "", true
+	}
+	domain := emailDomain(email)
+	if domain == "" {
+		return "email address is invalid", false
+	}
+	if _, ok := workspace.EmailDomainApproval[domain]; ok {
+		return "", true
+	}
+	if slices.ContainsFunc(workspace.EmailDomainWhitelist, func(d string) bool { return strings.EqualFold(d, domain) }) {
+		return "", true
+	}
+	return fmt.Sprintf("email domain %q is not permitted", domain), false
+}
+
+// memberApprovalMode resolves the approval mode for a new member, a provider that auto-accepts
+// takes precedence over a per-domain mode, which takes precedence over the workspace mode
+func memberApprovalMode(workspace *rubix.Workspace, email string, provider *rubix.OIDCProvider) string {
+	if provider != nil && provider.AutoAcceptMembers {
+		return rubix.MemberApprovalModeAuto
+	}
+	if mode, ok := workspace.EmailDomainApproval[emailDomain(email)]; ok && mode != "" {
+		return mode
+	}
+	if workspace.MemberApprovalMode == "" {
+		return rubix.MemberApprovalModeAuto
+	}
+	return workspace.MemberApprovalMode
+}